@@ -2,21 +2,92 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/ed25519"
 	"crypto/sha256"
+	"encoding/gob"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"hash"
+	"io"
+	"math"
+	"math/big"
+	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
-// Transaction represents a basic transaction in the blockchain
+// MaxDataSize is the largest payload a data transaction may anchor
+const MaxDataSize = 256
+
+// Transaction represents a basic transaction in the blockchain. A data
+// transaction has no sender, receiver or amount and only carries Data.
 type Transaction struct {
 	ID       string
 	Sender   *Account
 	Receiver *Account
 	Amount   float64
+	Data     []byte
+	Quote    *OracleQuote // optional external price the transfer relies on
+	Category string       // optional reporting tag such as "salary" or "refund"
+}
+
+// NewCategorizedTransaction creates a transfer tagged with a reporting category
+func NewCategorizedTransaction(sender, receiver *Account, amount float64, category string) *Transaction {
+	tx := &Transaction{
+		Sender:   sender,
+		Receiver: receiver,
+		Amount:   amount,
+		Category: category,
+	}
+	tx.ID = tx.hashTransaction()
+	return tx
+}
+
+// NewQuotedTransaction creates a transfer that relies on an oracle price quote
+func NewQuotedTransaction(sender, receiver *Account, amount float64, quote *OracleQuote) *Transaction {
+	tx := &Transaction{
+		Sender:   sender,
+		Receiver: receiver,
+		Amount:   amount,
+		Quote:    quote,
+	}
+	tx.ID = tx.hashTransaction()
+	return tx
+}
+
+// OracleQuote is a price statement signed by an external oracle
+type OracleQuote struct {
+	Asset     string
+	Price     float64
+	Timestamp time.Time
+	Signature []byte
+}
+
+// NewOracleQuote creates a quote signed with the oracle's private key
+func NewOracleQuote(key ed25519.PrivateKey, asset string, price float64, timestamp time.Time) *OracleQuote {
+	quote := &OracleQuote{Asset: asset, Price: price, Timestamp: canonicalTime(timestamp)}
+	quote.Signature = ed25519.Sign(key, quote.message())
+	return quote
+}
+
+// message returns the bytes the oracle signs; the price is written in its
+// shortest exact form so no digit of it can change without breaking the signature
+func (q *OracleQuote) message() []byte {
+	return []byte(fmt.Sprintf("%s|%s|%d", q.Asset, strconv.FormatFloat(q.Price, 'g', -1, 64), q.Timestamp.UnixNano()))
+}
+
+// Verify reports whether the quote was signed by the oracle holding publicKey
+func (q *OracleQuote) Verify(publicKey ed25519.PublicKey) bool {
+	return len(publicKey) == ed25519.PublicKeySize && ed25519.Verify(publicKey, q.message(), q.Signature)
 }
 
 // NewTransaction creates a new transaction and generates its ID
@@ -30,21 +101,110 @@ func NewTransaction(sender, receiver *Account, amount float64) *Transaction {
 	return tx
 }
 
-// hashTransaction generates a hash ID for the transaction
+// NewDataTransaction creates a value-less transaction anchoring data on-chain; its ID is the hash of "data:" and the data
+func NewDataTransaction(data []byte) (*Transaction, error) {
+	if len(data) == 0 {
+		return nil, errors.New("data transaction needs a payload")
+	}
+	if len(data) > MaxDataSize {
+		return nil, fmt.Errorf("data payload of %d bytes exceeds the %d byte limit", len(data), MaxDataSize)
+	}
+	tx := &Transaction{Data: data}
+	tx.ID = tx.hashTransaction()
+	return tx, nil
+}
+
+// Cost model used by EstimateTxCost
+const (
+	TxBaseCost = 100 // flat cost of every transaction
+	TxByteCost = 1   // cost per byte of account names, data payload, category and oracle quote
+)
+
+// EstimateTxCost returns a deterministic size-based cost for tx, so clients can
+// see what a transaction would cost before submitting it
+func EstimateTxCost(tx *Transaction) int {
+	size := len(tx.Data)
+	if tx.Sender != nil {
+		size += len(tx.Sender.Username)
+	}
+	if tx.Receiver != nil {
+		size += len(tx.Receiver.Username)
+	}
+	if tx.Quote != nil {
+		// The price and timestamp count as 8 bytes each
+		size += len(tx.Quote.Asset) + 8 + 8 + len(tx.Quote.Signature)
+	}
+	size += len(tx.Category)
+	return TxBaseCost + size*TxByteCost
+}
+
+// IsData reports whether tx is a data transaction rather than a value transfer
+func (tx *Transaction) IsData() bool {
+	return tx.Data != nil
+}
+
+// hashTransaction generates a hash ID for the transaction. Data records carry a
+// "data:" prefix, which no transfer record can start with because usernames may
+// not contain ':', so a payload cannot reproduce a transfer's ID.
 func (tx *Transaction) hashTransaction() string {
-	record := tx.Sender.Username + tx.Receiver.Username + fmt.Sprintf("%f", tx.Amount)
+	record := "data:" + string(tx.Data)
+	if !tx.IsData() {
+		record = tx.Sender.Username + tx.Receiver.Username + fmt.Sprintf("%f", tx.Amount)
+	}
+	if tx.Quote != nil {
+		record += hex.EncodeToString(tx.Quote.Signature)
+	}
+	if tx.Category != "" {
+		record += "category:" + tx.Category
+	}
 	hash := sha256.New()
 	hash.Write([]byte(record))
 	hashed := hash.Sum(nil)
 	return hex.EncodeToString(hashed)
 }
 
+// AtomicSwap bundles two opposing transfers that are committed together or not at all
+type AtomicSwap struct {
+	First  *Transaction
+	Second *Transaction
+}
+
+// NewAtomicSwap creates a swap where a pays b amountAB and b pays a amountBA
+func NewAtomicSwap(a, b *Account, amountAB, amountBA float64) *AtomicSwap {
+	return &AtomicSwap{
+		First:  NewTransaction(a, b, amountAB),
+		Second: NewTransaction(b, a, amountBA),
+	}
+}
+
+// Validate checks both legs of the swap and returns the first problem found
+func (s *AtomicSwap) Validate() error {
+	for i, leg := range []*Transaction{s.First, s.Second} {
+		if leg.Sender == nil || leg.Receiver == nil {
+			return fmt.Errorf("swap leg %d: missing sender or receiver", i+1)
+		}
+		if leg.Sender == leg.Receiver {
+			return fmt.Errorf("swap leg %d: sender and receiver are the same account", i+1)
+		}
+		if leg.Amount <= 0 {
+			return fmt.Errorf("swap leg %d: amount must be positive", i+1)
+		}
+	}
+	if s.First.Sender != s.Second.Receiver || s.First.Receiver != s.Second.Sender {
+		return errors.New("swap legs must be opposing transfers between the same two accounts")
+	}
+	return nil
+}
+
 // Block represents a block in the blockchain
 type Block struct {
 	Timestamp     time.Time
 	Transactions  []*Transaction
 	PrevBlockHash string
 	Hash          string
+	Difficulty    int
+	Message       string // free-form text, only set on the genesis block
+	trusted       bool   // imported from a trusted snapshot; proof of work is not rechecked
 }
 
 // Blockchain represents the entire chain
@@ -52,10 +212,23 @@ type Blockchain struct {
 	Blocks []*Block
 }
 
+// Clock supplies the current time to the VM so tests can control it
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the default Clock backed by the system time
+type realClock struct{}
+
+// Now returns the current system time
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
 // NewBlock creates a new block containing transactions
-func NewBlock(transactions []*Transaction, prevBlockHash string) *Block {
+func NewBlock(transactions []*Transaction, prevBlockHash string, timestamp time.Time) *Block {
 	block := &Block{
-		Timestamp:     time.Now(),
+		Timestamp:     canonicalTime(timestamp),
 		Transactions:  transactions,
 		PrevBlockHash: prevBlockHash,
 	}
@@ -63,153 +236,2473 @@ func NewBlock(transactions []*Transaction, prevBlockHash string) *Block {
 	return block
 }
 
+// canonicalTime strips the monotonic clock reading and converts t to UTC, so a
+// block's hash is unchanged after its timestamp is serialized and read back
+func canonicalTime(t time.Time) time.Time {
+	return t.Round(0).UTC()
+}
+
+// HashConfig names the hash function used to hash blocks
+type HashConfig struct {
+	Name string
+	New  func() hash.Hash
+}
+
+// SHA256Config is the hash configuration used for all blocks the VM creates
+var SHA256Config = HashConfig{Name: "sha256", New: sha256.New}
+
 // hashBlock generates a hash for the block
 func (b *Block) hashBlock() string {
-	record := fmt.Sprintf("%s%s", b.Timestamp.String(), b.PrevBlockHash)
+	return b.hashBlockWith(SHA256Config)
+}
+
+// hashBlockWith generates a hash for the block using the given hash configuration
+func (b *Block) hashBlockWith(config HashConfig) string {
+	hash := config.New()
+	hash.Write([]byte(b.hashRecord()))
+	hashed := hash.Sum(nil)
+	return hex.EncodeToString(hashed)
+}
+
+// hashRecord returns the exact string that hashBlock feeds to the hash function.
+// The difficulty is terminated and the message length-prefixed so that no two
+// different blocks share a record.
+func (b *Block) hashRecord() string {
+	record := fmt.Sprintf("%s%s%d|%d:%s", b.Timestamp.String(), b.PrevBlockHash, b.Difficulty, len(b.Message), b.Message)
 	for _, tx := range b.Transactions {
 		record += tx.ID
 	}
-	hash := sha256.New()
-	hash.Write([]byte(record))
-	hashed := hash.Sum(nil)
-	return hex.EncodeToString(hashed)
+	return record
 }
 
 // NewBlockchain creates a new blockchain with a genesis block
-func NewBlockchain() *Blockchain {
-	genesisBlock := NewBlock([]*Transaction{}, "")
+func NewBlockchain(timestamp time.Time) *Blockchain {
+	return NewBlockchainWithMessage(timestamp, "")
+}
+
+// NewBlockchainWithMessage creates a new blockchain whose genesis block embeds message in its hash
+func NewBlockchainWithMessage(timestamp time.Time, message string) *Blockchain {
+	genesisBlock := &Block{
+		Timestamp:    canonicalTime(timestamp),
+		Transactions: []*Transaction{},
+		Message:      message,
+	}
+	genesisBlock.Hash = genesisBlock.hashBlock()
 	return &Blockchain{Blocks: []*Block{genesisBlock}}
 }
 
 // AddBlock adds a new block to the blockchain
-func (bc *Blockchain) AddBlock(transactions []*Transaction) {
+func (bc *Blockchain) AddBlock(transactions []*Transaction, timestamp time.Time) {
 	prevBlock := bc.Blocks[len(bc.Blocks)-1]
-	newBlock := NewBlock(transactions, prevBlock.Hash)
+	newBlock := NewBlock(transactions, prevBlock.Hash, timestamp)
 	bc.Blocks = append(bc.Blocks, newBlock)
 }
 
-type VirtualMachine struct {
-	Blockchain *Blockchain
-	Accounts   map[string]*Account
+// BlocksInRange returns a copy of the blocks from fromHeight to toHeight inclusive
+func (bc *Blockchain) BlocksInRange(fromHeight, toHeight int) ([]*Block, error) {
+	if fromHeight > toHeight {
+		return nil, fmt.Errorf("invalid range: from height %d is after to height %d", fromHeight, toHeight)
+	}
+	if fromHeight < 0 || toHeight >= len(bc.Blocks) {
+		return nil, fmt.Errorf("range %d-%d out of bounds (0-%d)", fromHeight, toHeight, len(bc.Blocks)-1)
+	}
+	return append([]*Block{}, bc.Blocks[fromHeight:toHeight+1]...), nil
 }
 
-// NewVirtualMachine initializes a new VM with an empty blockchain and account map
-func NewVirtualMachine() *VirtualMachine {
-	return &VirtualMachine{
-		Blockchain: NewBlockchain(),
-		Accounts:   make(map[string]*Account),
+// BlocksBetweenTimes returns the blocks timestamped within [from, to] in chain
+// order. Timestamps are not guaranteed to increase (an injected clock, a
+// compacted chain or a loaded file can reorder them), so every block is checked.
+func (bc *Blockchain) BlocksBetweenTimes(from, to time.Time) []*Block {
+	var blocks []*Block
+	for _, block := range bc.Blocks {
+		if !block.Timestamp.Before(from) && !block.Timestamp.After(to) {
+			blocks = append(blocks, block)
+		}
 	}
+	return blocks
 }
 
-// CreateAccount creates a new account with the given username
-func (vm *VirtualMachine) CreateAccount(username string) *Account {
-	if _, exists := vm.Accounts[username]; exists {
-		fmt.Printf("Account with username %s already exists.\n", username)
-		return nil
+// meetsDifficulty reports whether hash has at least difficulty leading zero hex digits
+func meetsDifficulty(hash string, difficulty int) bool {
+	return strings.HasPrefix(hash, strings.Repeat("0", difficulty))
+}
+
+// ValidateChain checks that every transaction ID and block hash matches its
+// contents, that every block links to its predecessor, and that each
+// non-genesis block's hash satisfies the difficulty it records unless the
+// block was imported as trusted
+func (bc *Blockchain) ValidateChain() error {
+	return bc.validateChainWith(SHA256Config)
+}
+
+// validateChainWith runs the ValidateChain checks with block hashes computed under config
+func (bc *Blockchain) validateChainWith(config HashConfig) error {
+	if len(bc.Blocks) == 0 {
+		return errors.New("chain has no genesis block")
 	}
-	account := NewAccount(username)
-	vm.Accounts[username] = account
-	fmt.Printf("Account created: %s\n", username)
-	return account
+	for i, block := range bc.Blocks {
+		if block == nil {
+			return fmt.Errorf("block %d is missing", i)
+		}
+		for j, tx := range block.Transactions {
+			if tx == nil {
+				return fmt.Errorf("block %d: transaction %d is missing", i, j)
+			}
+			if !tx.IsData() && (tx.Sender == nil || tx.Receiver == nil) {
+				return fmt.Errorf("block %d: transaction %s is missing its sender or receiver", i, tx.ID)
+			}
+			if tx.ID != tx.hashTransaction() {
+				return fmt.Errorf("block %d: transaction %s does not match its contents", i, tx.ID)
+			}
+		}
+		if block.Hash != block.hashBlockWith(config) {
+			return fmt.Errorf("block %d: stored hash does not match contents", i)
+		}
+		if i == 0 {
+			continue
+		}
+		if block.PrevBlockHash != bc.Blocks[i-1].Hash {
+			return fmt.Errorf("block %d: previous hash does not match block %d", i, i-1)
+		}
+		if !block.trusted && !meetsDifficulty(block.Hash, block.Difficulty) {
+			return fmt.Errorf("block %d: hash does not meet difficulty %d", i, block.Difficulty)
+		}
+	}
+	return nil
 }
 
-// ProcessTransaction handles a single transaction
-func (vm *VirtualMachine) ProcessTransaction(tx *Transaction) {
-	fmt.Printf("Processing Transaction: ID=%s, From=%s, To=%s, Amount=%.2f\n",
-		tx.ID, tx.Sender.Username, tx.Receiver.Username, tx.Amount)
-	// In a real system, we would update balances, etc.
+// MigrateChain rebuilds the chain under a new hash configuration, re-hashing and
+// re-linking every block while keeping timestamps, transactions and difficulty.
+// The source chain must validate under from. This is a maintenance tool for
+// format changes, not something peers should accept as consensus.
+func (bc *Blockchain) MigrateChain(from, to HashConfig) (*Blockchain, error) {
+	if err := bc.validateChainWith(from); err != nil {
+		return nil, fmt.Errorf("source chain is not valid under %s: %w", from.Name, err)
+	}
+	migrated := &Blockchain{Blocks: make([]*Block, 0, len(bc.Blocks))}
+	prevHash := ""
+	for _, block := range bc.Blocks {
+		newBlock := &Block{
+			Timestamp:     block.Timestamp,
+			Transactions:  block.Transactions,
+			PrevBlockHash: prevHash,
+			Difficulty:    block.Difficulty,
+			Message:       block.Message,
+		}
+		newBlock.Hash = newBlock.hashBlockWith(to)
+		migrated.Blocks = append(migrated.Blocks, newBlock)
+		prevHash = newBlock.Hash
+	}
+	return migrated, nil
 }
 
-// ExecuteBlock processes all transactions in a block
-func (vm *VirtualMachine) ExecuteBlock(block *Block) {
-	for _, tx := range block.Transactions {
-		vm.ProcessTransaction(tx)
+// CompactChain rebuilds the chain with transactions repacked into blocks of up
+// to maxTxPerBlock, re-linking and re-hashing everything after the untouched
+// genesis block. Transactions from one original block are never split, so
+// atomic swaps stay together, and each new block keeps the timestamp of the
+// last original block packed into it. This is a dev tool that rewrites history
+// and is not safe for chains shared with anyone else.
+func (bc *Blockchain) CompactChain(maxTxPerBlock int) *Blockchain {
+	if maxTxPerBlock < 1 {
+		maxTxPerBlock = 1
+	}
+	compacted := &Blockchain{Blocks: []*Block{bc.Blocks[0]}}
+	var pending []*Transaction
+	var pendingTime time.Time
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		compacted.AddBlock(pending, pendingTime)
+		pending = nil
+	}
+	for _, block := range bc.Blocks[1:] {
+		if len(block.Transactions) == 0 {
+			continue
+		}
+		if len(pending)+len(block.Transactions) > maxTxPerBlock {
+			flush()
+		}
+		pending = append(pending, block.Transactions...)
+		pendingTime = block.Timestamp
 	}
+	flush()
+	return compacted
 }
 
-// AddBlockToChain adds a block to the blockchain and processes it
-func (vm *VirtualMachine) AddBlockToChain(transactions []*Transaction) {
-	vm.Blockchain.AddBlock(transactions)
-	vm.ExecuteBlock(vm.Blockchain.Blocks[len(vm.Blockchain.Blocks)-1])
+// Codec converts a blockchain to and from a serialized form
+type Codec interface {
+	Encode(bc *Blockchain) ([]byte, error)
+	Decode(data []byte) (*Blockchain, error)
 }
 
-// GetAccount retrieves an account by username
-func (vm *VirtualMachine) GetAccount(username string) *Account {
-	return vm.Accounts[username]
+// JSONCodec serializes chains as JSON
+type JSONCodec struct{}
+
+// Encode serializes bc as indented JSON
+func (JSONCodec) Encode(bc *Blockchain) ([]byte, error) {
+	return json.MarshalIndent(bc, "", "  ")
 }
 
-func main() {
-	vm := NewVirtualMachine()
+// Decode parses a JSON-serialized chain
+func (JSONCodec) Decode(data []byte) (*Blockchain, error) {
+	var bc Blockchain
+	if err := json.Unmarshal(data, &bc); err != nil {
+		return nil, err
+	}
+	return &bc, nil
+}
 
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		fmt.Println("\nCommands:")
-		fmt.Println("1. create_account [username]")
-		fmt.Println("2. send [sender] [receiver] [amount]")
-		fmt.Println("3. view_blockchain")
-		fmt.Println("4. exit")
+// GobCodec serializes chains with encoding/gob
+type GobCodec struct{}
 
-		fmt.Print("Enter command: ")
-		command, _ := reader.ReadString('\n')
-		command = strings.TrimSpace(command)
+// Encode serializes bc with gob
+func (GobCodec) Encode(bc *Blockchain) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(bc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode parses a gob-serialized chain
+func (GobCodec) Decode(data []byte) (*Blockchain, error) {
+	var bc Blockchain
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&bc); err != nil {
+		return nil, err
+	}
+	return &bc, nil
+}
+
+// CodecByName returns the codec registered under name ("json" or "gob")
+func CodecByName(name string) (Codec, error) {
+	switch name {
+	case "json":
+		return JSONCodec{}, nil
+	case "gob":
+		return GobCodec{}, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+// SaveToFile writes the chain to path using codec
+func (bc *Blockchain) SaveToFile(path string, codec Codec) error {
+	data, err := codec.Encode(bc)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
 
-		parts := strings.Split(command, " ")
+// LoadFromFile reads a chain written by SaveToFile with the same codec
+func LoadFromFile(path string, codec Codec) (*Blockchain, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := codec.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(bc.Blocks) == 0 {
+		return nil, errors.New("chain has no genesis block")
+	}
+	return bc, nil
+}
 
-		switch parts[0] {
-		case "create_account":
-			if len(parts) != 2 {
-				fmt.Println("Usage: create_account [username]")
-			} else {
-				vm.CreateAccount(parts[1])
+// ExportDOT writes the chain as a Graphviz DOT graph with one node per block,
+// labelled with its height, short hash and transaction count, and an edge from
+// each block to the block its PrevBlockHash points at
+func (bc *Blockchain) ExportDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph blockchain {\n  rankdir=LR;"); err != nil {
+		return err
+	}
+	for i, block := range bc.Blocks {
+		if _, err := fmt.Fprintf(w, "  \"%s\" [label=\"#%d\\n%.8s\\n%d tx\"];\n",
+			block.Hash, i, block.Hash, len(block.Transactions)); err != nil {
+			return err
+		}
+		if block.PrevBlockHash != "" {
+			if _, err := fmt.Fprintf(w, "  \"%s\" -> \"%s\";\n", block.Hash, block.PrevBlockHash); err != nil {
+				return err
 			}
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
 
-		case "send":
-			if len(parts) != 4 {
-				fmt.Println("Usage: send [sender] [receiver] [amount]")
-			} else {
-				sender := vm.GetAccount(parts[1])
-				receiver := vm.GetAccount(parts[2])
-				if sender == nil || receiver == nil {
-					fmt.Println("Invalid sender or receiver.")
-					break
-				}
-				amount, err := strconv.ParseFloat(parts[3], 64)
-				if err != nil {
-					fmt.Println("Invalid amount.")
-					break
-				}
-				tx := NewTransaction(sender, receiver, amount)
-				vm.AddBlockToChain([]*Transaction{tx})
+// LongestGap returns the heights of the consecutive blocks with the largest
+// time between them and that duration; a chain of only genesis returns 0, 0, 0
+func (bc *Blockchain) LongestGap() (start, end int, duration time.Duration) {
+	for i := 1; i < len(bc.Blocks); i++ {
+		gap := bc.Blocks[i].Timestamp.Sub(bc.Blocks[i-1].Timestamp)
+		if i == 1 || gap > duration {
+			start, end, duration = i-1, i, gap
+		}
+	}
+	return start, end, duration
+}
+
+// TxCountSeries returns the number of transactions in each block in height
+// order; data transactions are only counted if includeData is set
+func (bc *Blockchain) TxCountSeries(includeData bool) []int {
+	series := make([]int, len(bc.Blocks))
+	for i, block := range bc.Blocks {
+		for _, tx := range block.Transactions {
+			if includeData || !tx.IsData() {
+				series[i]++
 			}
+		}
+	}
+	return series
+}
 
-		case "view_blockchain":
-			viewBlockchain(vm)
+// VerifyChainFile decodes the chain at path (gob if it ends in ".gob", JSON
+// otherwise) and runs ValidateChain on it without touching any VM. It returns
+// false with the reason if the file cannot be read, decoded or validated.
+func VerifyChainFile(path string) (bool, error) {
+	var codec Codec = JSONCodec{}
+	if strings.HasSuffix(path, ".gob") {
+		codec = GobCodec{}
+	}
+	bc, err := LoadFromFile(path, codec)
+	if err != nil {
+		return false, err
+	}
+	if err := bc.ValidateChain(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-		case "exit":
-			fmt.Println("Exiting...")
-			return
+// ChainDigest returns a single SHA-256 hash over every block hash in order,
+// genesis included, so two chains are equal exactly when their digests match
+func (bc *Blockchain) ChainDigest() string {
+	hash := sha256.New()
+	for _, block := range bc.Blocks {
+		hash.Write([]byte(block.Hash))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
 
-		default:
-			fmt.Println("Unknown command")
+// CheckUniqueTxIDs returns, in order of first appearance, every transaction
+// ID that occurs more than once across the chain
+func (bc *Blockchain) CheckUniqueTxIDs() []string {
+	seen := make(map[string]int)
+	var duplicates []string
+	for _, block := range bc.Blocks {
+		for _, tx := range block.Transactions {
+			seen[tx.ID]++
+			if seen[tx.ID] == 2 {
+				duplicates = append(duplicates, tx.ID)
+			}
 		}
 	}
+	return duplicates
 }
 
-// viewBlockchain prints the entire blockchain
-func viewBlockchain(vm *VirtualMachine) {
-	for i, block := range vm.Blockchain.Blocks {
-		fmt.Printf("Block %d:\n", i)
-		fmt.Printf("Hash: %s\n", block.Hash)
-		fmt.Printf("Previous Hash: %s\n", block.PrevBlockHash)
-		for _, tx := range block.Transactions {
-			fmt.Printf("  TxID: %s | From: %s | To: %s | Amount: %.2f\n", tx.ID, tx.Sender.Username, tx.Receiver.Username, tx.Amount)
+// DiffChains compares two chains and returns the height of the last block they
+// share (matching hash at the same height) along with the blocks unique to each.
+// A commonHeight of -1 means the chains do not even share a genesis block.
+func DiffChains(a, b *Blockchain) (commonHeight int, aOnly, bOnly []*Block) {
+	commonHeight = -1
+	for i := 0; i < len(a.Blocks) && i < len(b.Blocks); i++ {
+		if a.Blocks[i].Hash != b.Blocks[i].Hash {
+			break
 		}
+		commonHeight = i
 	}
+	aOnly = append([]*Block{}, a.Blocks[commonHeight+1:]...)
+	bOnly = append([]*Block{}, b.Blocks[commonHeight+1:]...)
+	return commonHeight, aOnly, bOnly
 }
 
-// Account represents a user account with just a username
-type Account struct {
-	Username string
+// TransactionView is the JSON-serializable form of a transaction
+type TransactionView struct {
+	ID       string  `json:"id"`
+	From     string  `json:"from,omitempty"`
+	FromName string  `json:"from_name,omitempty"`
+	To       string  `json:"to,omitempty"`
+	ToName   string  `json:"to_name,omitempty"`
+	Amount   float64 `json:"amount"`
+	Data     string  `json:"data,omitempty"` // hex payload of a data transaction
+	Category string  `json:"category,omitempty"`
+}
+
+// BlockView is the JSON-serializable form of a block at a given height
+type BlockView struct {
+	Height       int               `json:"height"`
+	Hash         string            `json:"hash"`
+	PrevHash     string            `json:"prev_hash"`
+	Timestamp    time.Time         `json:"timestamp"`
+	Message      string            `json:"message,omitempty"`
+	Transactions []TransactionView `json:"transactions"`
+}
+
+// RejectedTransaction is the dead-letter record written for each rejected transaction
+type RejectedTransaction struct {
+	Timestamp time.Time `json:"timestamp"`
+	Sender    string    `json:"sender"`
+	Receiver  string    `json:"receiver"`
+	Amount    string    `json:"amount"`
+	Reason    string    `json:"reason"`
+}
+
+// CheckResult is the outcome of one validation check on a transaction
+type CheckResult struct {
+	Name   string
+	Passed bool
+	Detail string
+}
+
+// TipInfo describes the latest block of the chain
+type TipInfo struct {
+	Height    int
+	Hash      string
+	Timestamp time.Time
+}
+
+// BalanceChange is a single credit (positive Delta) or debit (negative Delta) to an account
+type BalanceChange struct {
+	Height int
+	TxID   string
+	Delta  float64
+}
+
+// txWatcher is a pending WatchTransaction registration
+type txWatcher struct {
+	txID          string
+	confirmations int
+	callback      func(*Transaction)
+}
+
+type VirtualMachine struct {
+	Blockchain      *Blockchain
+	Accounts        map[string]*Account
+	DeadLetter      io.Writer    // optional sink receiving one JSON line per rejected transaction
+	DisplayDecimals int          // decimal places amounts are shown with and may be entered with
+	DisplayHashLen  int          // hex digits of hashes shown in listings; 0 shows full hashes
+	TimeFormat      string       // layout timestamps are shown with; empty means time.RFC3339
+	RoundingMode    RoundingMode // how amounts are rounded to DisplayDecimals for display
+	UsernamePolicy  UsernamePolicy
+	OraclePublicKey ed25519.PublicKey  // key oracle quotes attached to transactions must be signed with
+	MinAmount       float64            // smallest amount a transfer may carry; 0 disables the rule
+	AmountUnits     map[string]float64 // suffixes ParseAmount accepts, mapped to their multiplier
+	ProvenanceDepth int                // how many funding hops Provenance follows
+	TrustImported   bool               // LoadChain skips proof-of-work checks, but not hash links, for imported blocks
+	Deterministic   bool               // block timestamps are logical (the block height) rather than wall-clock
+	aliases         map[string]string
+	clock           Clock
+	watchers        []*txWatcher
+	readOnly        bool
+	maintenance     bool
+}
+
+// NewVirtualMachine initializes a new VM with an empty blockchain and account map
+func NewVirtualMachine() *VirtualMachine {
+	return NewVirtualMachineWithClock(realClock{})
+}
+
+// RoundingMode selects how amounts are rounded to the display precision
+type RoundingMode int
+
+const (
+	RoundHalfEven RoundingMode = iota // halves go to the even digit (banker's rounding)
+	RoundHalfUp                       // halves go away from zero
+	RoundDown                         // extra digits are truncated toward zero
+)
+
+// roundingModeNames maps the config names of the rounding modes to their values
+var roundingModeNames = map[string]RoundingMode{
+	"half_even": RoundHalfEven,
+	"half_up":   RoundHalfUp,
+	"down":      RoundDown,
+}
+
+// ParseRoundingMode returns the rounding mode called name ("half_even", "half_up" or "down")
+func ParseRoundingMode(name string) (RoundingMode, error) {
+	mode, ok := roundingModeNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown rounding mode %q (use half_even, half_up or down)", name)
+	}
+	return mode, nil
+}
+
+// DefaultAmountUnits lets amounts be entered in thousands ("1k") or millions ("0.5m")
+var DefaultAmountUnits = map[string]float64{
+	"k": 1e3,
+	"m": 1e6,
+}
+
+// ErrReadOnly is returned by write operations on a read-only VM
+var ErrReadOnly = errors.New("virtual machine is read-only")
+
+// ErrMaintenance is returned by write operations while the VM is in maintenance mode
+var ErrMaintenance = errors.New("virtual machine is in maintenance mode")
+
+// ErrAccountExists is returned when creating an account whose username is taken
+var ErrAccountExists = errors.New("account already exists")
+
+// ErrInvalidUsername is returned when a username breaks the VM's UsernamePolicy
+var ErrInvalidUsername = errors.New("invalid username")
+
+// UsernamePolicy describes which usernames CreateAccount accepts
+type UsernamePolicy struct {
+	MinLength     int    `json:"min_length"`
+	MaxLength     int    `json:"max_length"`     // 0 means no upper limit
+	AllowedChars  string `json:"allowed_chars"`  // empty means any non-space character; ':' is never allowed
+	CaseSensitive bool   `json:"case_sensitive"` // when false, usernames are stored and looked up in lower case
+}
+
+// DefaultUsernamePolicy allows 1-32 letters, digits, '_', '-' and '.'
+var DefaultUsernamePolicy = UsernamePolicy{
+	MinLength:     1,
+	MaxLength:     32,
+	AllowedChars:  "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.",
+	CaseSensitive: true,
+}
+
+// Validate returns an error wrapping ErrInvalidUsername that names the rule username breaks
+func (p UsernamePolicy) Validate(username string) error {
+	length := utf8.RuneCountInString(username)
+	if length < p.MinLength {
+		return fmt.Errorf("%w: %q is shorter than %d characters", ErrInvalidUsername, username, p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return fmt.Errorf("%w: %q is longer than %d characters", ErrInvalidUsername, username, p.MaxLength)
+	}
+	for _, r := range username {
+		if unicode.IsSpace(r) || r == ':' || (p.AllowedChars != "" && !strings.ContainsRune(p.AllowedChars, r)) {
+			return fmt.Errorf("%w: %q contains disallowed character %q", ErrInvalidUsername, username, r)
+		}
+	}
+	return nil
+}
+
+// VMOptions configures a new VM; zero values select the defaults
+type VMOptions struct {
+	Clock          Clock
+	GenesisMessage string
+	Deterministic  bool // stamp blocks with their height instead of the clock's time
+}
+
+// NewVirtualMachineWithClock initializes a new VM whose block timestamps come from clock
+func NewVirtualMachineWithClock(clock Clock) *VirtualMachine {
+	return NewVirtualMachineWithOptions(VMOptions{Clock: clock})
+}
+
+// NewVirtualMachineWithOptions initializes a new VM configured by opts
+func NewVirtualMachineWithOptions(opts VMOptions) *VirtualMachine {
+	clock := opts.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	vm := &VirtualMachine{
+		Accounts:        make(map[string]*Account),
+		aliases:         make(map[string]string),
+		DisplayDecimals: 2,
+		DisplayHashLen:  12,
+		TimeFormat:      time.RFC3339,
+		UsernamePolicy:  DefaultUsernamePolicy,
+		AmountUnits:     DefaultAmountUnits,
+		ProvenanceDepth: 3,
+		Deterministic:   opts.Deterministic,
+		clock:           clock,
+	}
+	vm.Blockchain = NewBlockchainWithMessage(vm.blockTime(0), opts.GenesisMessage)
+	return vm
+}
+
+// Config holds every tunable VM setting so a deployment can be described by a
+// single JSON file; zero-valued fields missing from the file keep their defaults
+type Config struct {
+	GenesisMessage  string             `json:"genesis_message"`
+	Deterministic   bool               `json:"deterministic"`
+	TrustImported   bool               `json:"trust_imported"`
+	DisplayDecimals int                `json:"display_decimals"`
+	DisplayHashLen  int                `json:"display_hash_len"`
+	TimeFormat      string             `json:"time_format"`
+	RoundingMode    string             `json:"rounding_mode"`
+	MinAmount       float64            `json:"min_amount"`
+	ProvenanceDepth int                `json:"provenance_depth"`
+	AmountUnits     map[string]float64 `json:"amount_units"`
+	UsernamePolicy  UsernamePolicy     `json:"username_policy"`
+	OraclePublicKey string             `json:"oracle_public_key"` // hex-encoded ed25519 key; empty accepts no quotes
+	DeadLetter      string             `json:"dead_letter"`       // path of the dead-letter file, opened by the caller
+}
+
+// DefaultConfig returns the settings NewVirtualMachine uses
+func DefaultConfig() Config {
+	// Copy the units so changing a Config cannot alter DefaultAmountUnits
+	units := make(map[string]float64, len(DefaultAmountUnits))
+	for unit, multiplier := range DefaultAmountUnits {
+		units[unit] = multiplier
+	}
+	return Config{
+		DisplayDecimals: 2,
+		DisplayHashLen:  12,
+		TimeFormat:      time.RFC3339,
+		RoundingMode:    "half_even",
+		ProvenanceDepth: 3,
+		AmountUnits:     units,
+		UsernamePolicy:  DefaultUsernamePolicy,
+	}
+}
+
+// LoadConfig reads a JSON config file over DefaultConfig and validates the result
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	// Decode the units into an empty map so a file that lists them replaces
+	// the defaults rather than adding to them
+	defaultUnits := config.AmountUnits
+	config.AmountUnits = nil
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if config.AmountUnits == nil {
+		config.AmountUnits = defaultUnits
+	}
+	if err := config.Validate(); err != nil {
+		return config, fmt.Errorf("config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Validate returns an error naming the first setting that is out of range
+func (c Config) Validate() error {
+	if c.DisplayDecimals < 0 {
+		return fmt.Errorf("display_decimals must not be negative, got %d", c.DisplayDecimals)
+	}
+	if _, err := ParseRoundingMode(c.RoundingMode); err != nil {
+		return err
+	}
+	if c.DisplayHashLen < 0 {
+		return fmt.Errorf("display_hash_len must not be negative, got %d", c.DisplayHashLen)
+	}
+	if c.MinAmount < 0 || math.IsNaN(c.MinAmount) || math.IsInf(c.MinAmount, 0) {
+		return fmt.Errorf("min_amount must be a non-negative number, got %v", c.MinAmount)
+	}
+	if c.ProvenanceDepth < 0 {
+		return fmt.Errorf("provenance_depth must not be negative, got %d", c.ProvenanceDepth)
+	}
+	if c.UsernamePolicy.MinLength < 0 || c.UsernamePolicy.MaxLength < 0 {
+		return fmt.Errorf("username_policy lengths must not be negative, got %d and %d", c.UsernamePolicy.MinLength, c.UsernamePolicy.MaxLength)
+	}
+	if c.UsernamePolicy.MaxLength > 0 && c.UsernamePolicy.MaxLength < c.UsernamePolicy.MinLength {
+		return fmt.Errorf("username_policy max_length %d is below min_length %d", c.UsernamePolicy.MaxLength, c.UsernamePolicy.MinLength)
+	}
+	if _, err := c.oracleKey(); err != nil {
+		return err
+	}
+	for unit, multiplier := range c.AmountUnits {
+		if unit == "" || strings.TrimFunc(unit, unicode.IsLetter) != "" || unit != strings.ToLower(unit) {
+			return fmt.Errorf("amount unit %q must be lower-case letters", unit)
+		}
+		if multiplier <= 0 || math.IsInf(multiplier, 0) {
+			return fmt.Errorf("amount unit %q must have a positive multiplier, got %v", unit, multiplier)
+		}
+	}
+	return nil
+}
+
+// oracleKey decodes OraclePublicKey, returning nil if it is empty
+func (c Config) oracleKey() (ed25519.PublicKey, error) {
+	if c.OraclePublicKey == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(c.OraclePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("oracle_public_key must be %d hex-encoded bytes", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// NewVirtualMachineFromConfig initializes a new VM with the settings in config,
+// which is expected to have passed Validate
+func NewVirtualMachineFromConfig(config Config) *VirtualMachine {
+	vm := NewVirtualMachineWithOptions(VMOptions{
+		GenesisMessage: config.GenesisMessage,
+		Deterministic:  config.Deterministic,
+	})
+	vm.TrustImported = config.TrustImported
+	vm.DisplayDecimals = config.DisplayDecimals
+	vm.DisplayHashLen = config.DisplayHashLen
+	vm.TimeFormat = config.TimeFormat
+	vm.RoundingMode, _ = ParseRoundingMode(config.RoundingMode)
+	vm.MinAmount = config.MinAmount
+	vm.ProvenanceDepth = config.ProvenanceDepth
+	vm.AmountUnits = config.AmountUnits
+	vm.UsernamePolicy = config.UsernamePolicy
+	vm.OraclePublicKey, _ = config.oracleKey()
+	return vm
+}
+
+// NewReadOnlyVM returns an observer VM sharing source's chain and accounts.
+// Reads see the source's live state, while every write path returns ErrReadOnly.
+func NewReadOnlyVM(source *VirtualMachine) *VirtualMachine {
+	// Copy every setting so the observer answers queries exactly as the source would
+	observer := *source
+	observer.readOnly = true
+	observer.watchers = nil
+	return &observer
+}
+
+// checkWritable returns the reason the VM's state cannot currently be changed, if any
+func (vm *VirtualMachine) checkWritable() error {
+	if vm.readOnly {
+		return ErrReadOnly
+	}
+	if vm.maintenance {
+		return ErrMaintenance
+	}
+	return nil
+}
+
+// EnterMaintenance pauses the VM: every write returns ErrMaintenance until
+// ExitMaintenance, while reads carry on, so a consistent snapshot can be taken
+func (vm *VirtualMachine) EnterMaintenance() {
+	vm.maintenance = true
+}
+
+// ExitMaintenance lets writes resume after EnterMaintenance
+func (vm *VirtualMachine) ExitMaintenance() {
+	vm.maintenance = false
+}
+
+// InMaintenance reports whether the VM is in maintenance mode
+func (vm *VirtualMachine) InMaintenance() bool {
+	return vm.maintenance
+}
+
+// blockTime returns the timestamp for a new block at height. In deterministic
+// mode this is a logical time of height seconds after the Unix epoch, so block
+// hashes are reproducible; otherwise it is the clock's current time.
+func (vm *VirtualMachine) blockTime(height int) time.Time {
+	if vm.Deterministic {
+		return time.Unix(int64(height), 0).UTC()
+	}
+	return vm.clock.Now()
+}
+
+// CreateAccount creates a new account with the given username. It fails with
+// ErrReadOnly, ErrMaintenance, ErrInvalidUsername or ErrAccountExists.
+func (vm *VirtualMachine) CreateAccount(username string) (*Account, error) {
+	if err := vm.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := vm.UsernamePolicy.Validate(username); err != nil {
+		return nil, err
+	}
+	username = vm.normalizeUsername(username)
+	if _, exists := vm.Accounts[username]; exists {
+		return nil, fmt.Errorf("%w: %s", ErrAccountExists, username)
+	}
+	account := NewAccount(username)
+	vm.Accounts[username] = account
+	fmt.Printf("Account created: %s\n", username)
+	return account, nil
+}
+
+// CreateAccounts creates accounts named prefix0 through prefix{count-1},
+// skipping any that already exist. It returns the accounts it created and
+// stops at the first account that cannot be created.
+func (vm *VirtualMachine) CreateAccounts(prefix string, count int) ([]*Account, error) {
+	var created []*Account
+	for i := 0; i < count; i++ {
+		username := fmt.Sprintf("%s%d", prefix, i)
+		if vm.GetAccount(username) != nil {
+			continue
+		}
+		account, err := vm.CreateAccount(username)
+		if err != nil {
+			return created, err
+		}
+		created = append(created, account)
+	}
+	return created, nil
+}
+
+// ProcessTransaction handles a single transaction
+func (vm *VirtualMachine) ProcessTransaction(tx *Transaction) {
+	if tx.IsData() {
+		fmt.Printf("Processing Data Transaction: ID=%s, Data=%x\n", tx.ID, tx.Data)
+		return
+	}
+	fmt.Printf("Processing Transaction: ID=%s, From=%s, To=%s, Amount=%s\n",
+		tx.ID, tx.Sender.Label(), tx.Receiver.Label(), vm.FormatAmount(tx.Amount))
+	// In a real system, we would update balances, etc.
+}
+
+// ExecuteBlock processes all transactions in a block
+func (vm *VirtualMachine) ExecuteBlock(block *Block) {
+	for _, tx := range block.Transactions {
+		vm.ProcessTransaction(tx)
+	}
+}
+
+// AddBlockToChain adds a block to the blockchain and processes it
+func (vm *VirtualMachine) AddBlockToChain(transactions []*Transaction) error {
+	if err := vm.checkWritable(); err != nil {
+		return err
+	}
+	vm.Blockchain.AddBlock(transactions, vm.blockTime(len(vm.Blockchain.Blocks)))
+	vm.ExecuteBlock(vm.Blockchain.Blocks[len(vm.Blockchain.Blocks)-1])
+	vm.notifyWatchers()
+	return nil
+}
+
+// WatchTransaction calls callback once the transaction with txID has at least
+// the given number of confirmations; the block holding it counts as the first.
+// If the threshold is already met the callback fires immediately.
+func (vm *VirtualMachine) WatchTransaction(txID string, confirmations int, callback func(*Transaction)) {
+	vm.watchers = append(vm.watchers, &txWatcher{
+		txID:          txID,
+		confirmations: confirmations,
+		callback:      callback,
+	})
+	vm.notifyWatchers()
+}
+
+// notifyWatchers fires and removes every watcher whose transaction has reached its confirmation threshold
+func (vm *VirtualMachine) notifyWatchers() {
+	remaining := vm.watchers[:0]
+	var fired []*txWatcher
+	for _, w := range vm.watchers {
+		tx, height := vm.findTransaction(w.txID)
+		if tx != nil && len(vm.Blockchain.Blocks)-height >= w.confirmations {
+			fired = append(fired, w)
+			continue
+		}
+		remaining = append(remaining, w)
+	}
+	vm.watchers = remaining
+	for _, w := range fired {
+		tx, _ := vm.findTransaction(w.txID)
+		w.callback(tx)
+	}
+}
+
+// LoadChain replaces the VM's chain with bc after validating it. Accounts named
+// in bc's transactions must satisfy UsernamePolicy and are registered if missing,
+// and every transaction is pointed at the VM's own account objects.
+func (vm *VirtualMachine) LoadChain(bc *Blockchain) error {
+	if err := vm.checkWritable(); err != nil {
+		return err
+	}
+	if vm.TrustImported {
+		for _, block := range bc.Blocks {
+			if block != nil {
+				block.trusted = true
+			}
+		}
+	}
+	if err := bc.ValidateChain(); err != nil {
+		return err
+	}
+	// Every name is checked before vm.Accounts is touched, so a rejected chain
+	// leaves the VM unchanged. A name must match its account exactly: pointing a
+	// transfer from "Alice" at the account "alice" would change its hash.
+	check := func(account *Account) error {
+		if err := vm.UsernamePolicy.Validate(account.Username); err != nil {
+			return fmt.Errorf("chain account: %w", err)
+		}
+		if normalized := vm.normalizeUsername(account.Username); normalized != account.Username {
+			return fmt.Errorf("%w: chain account %q is stored as %q on this VM", ErrInvalidUsername, account.Username, normalized)
+		}
+		if existing := vm.GetAccount(account.Username); existing != nil && existing.Username != account.Username {
+			return fmt.Errorf("%w: chain account %q matches existing account %q", ErrInvalidUsername, account.Username, existing.Username)
+		}
+		return nil
+	}
+	for _, block := range bc.Blocks {
+		for _, tx := range block.Transactions {
+			if tx.IsData() {
+				continue
+			}
+			if err := check(tx.Sender); err != nil {
+				return err
+			}
+			if err := check(tx.Receiver); err != nil {
+				return err
+			}
+		}
+	}
+	resolve := func(account *Account) *Account {
+		if existing := vm.GetAccount(account.Username); existing != nil {
+			return existing
+		}
+		vm.Accounts[account.Username] = account
+		return account
+	}
+	for _, block := range bc.Blocks {
+		for _, tx := range block.Transactions {
+			if tx.IsData() {
+				continue
+			}
+			tx.Sender = resolve(tx.Sender)
+			tx.Receiver = resolve(tx.Receiver)
+		}
+	}
+	vm.Blockchain.Blocks = bc.Blocks
+	return nil
+}
+
+// ExecuteSwap validates both legs of a swap and, only if both pass, commits them together in one block
+func (vm *VirtualMachine) ExecuteSwap(swap *AtomicSwap) error {
+	if err := swap.Validate(); err != nil {
+		return err
+	}
+	for _, leg := range []*Transaction{swap.First, swap.Second} {
+		if err := vm.ValidateTransaction(leg); err != nil {
+			return fmt.Errorf("leg %s -> %s: %w", leg.Sender.Username, leg.Receiver.Username, err)
+		}
+	}
+	return vm.AddBlockToChain([]*Transaction{swap.First, swap.Second})
+}
+
+// ParseAmount parses a user-entered amount, which may carry a unit suffix from
+// AmountUnits, rejecting anything with more decimal places than DisplayDecimals
+// so it is never silently rounded
+func (vm *VirtualMachine) ParseAmount(s string) (float64, error) {
+	return vm.parseAmountWithUnits(s)
+}
+
+// parseAmountWithUnits splits an optional unit suffix off s and scales the
+// number by it, using exact arithmetic for the decimal places check
+func (vm *VirtualMachine) parseAmountWithUnits(s string) (float64, error) {
+	number := strings.TrimRightFunc(s, unicode.IsLetter)
+	multiplier := 1.0
+	if unit := s[len(number):]; unit != "" {
+		m, ok := vm.AmountUnits[strings.ToLower(unit)]
+		if !ok {
+			return 0, fmt.Errorf("unknown unit %q in amount %q (known units: %s)", unit, s, strings.Join(vm.unitNames(), ", "))
+		}
+		multiplier = m
+	}
+	amount, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) || strings.ContainsAny(number, "eExXpP_") {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	exact, ok := new(big.Rat).SetString(number)
+	if !ok {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	exact.Mul(exact, new(big.Rat).SetFloat64(multiplier))
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(vm.DisplayDecimals)), nil))
+	if !new(big.Rat).Mul(exact, scale).IsInt() {
+		return 0, fmt.Errorf("amount %s has more than %d decimal places", s, vm.DisplayDecimals)
+	}
+	amount, _ = exact.Float64()
+	return amount, nil
+}
+
+// unitNames returns the configured amount unit suffixes in sorted order
+func (vm *VirtualMachine) unitNames() []string {
+	names := make([]string, 0, len(vm.AmountUnits))
+	for name := range vm.AmountUnits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExplainValidation runs every validation check against a value transfer and
+// reports each outcome, so it is clear why the transfer would be accepted or rejected
+func (vm *VirtualMachine) ExplainValidation(tx *Transaction) []CheckResult {
+	registered := func(a *Account) CheckResult {
+		if a == nil || vm.GetAccount(a.Username) != a {
+			return CheckResult{Passed: false, Detail: "account is not registered"}
+		}
+		return CheckResult{Passed: true, Detail: a.Username}
+	}
+	sender := registered(tx.Sender)
+	sender.Name = "sender registered"
+	receiver := registered(tx.Receiver)
+	receiver.Name = "receiver registered"
+	return append([]CheckResult{sender, receiver}, vm.ruleChecks(tx)...)
+}
+
+// ruleChecks runs the validation checks that depend only on the transfer itself
+// and the VM's configured rules, not on which accounts are registered
+func (vm *VirtualMachine) ruleChecks(tx *Transaction) []CheckResult {
+	var checks []CheckResult
+	positive := CheckResult{Name: "positive amount", Passed: tx.Amount > 0}
+	if positive.Passed {
+		positive.Detail = vm.FormatAmount(tx.Amount)
+	} else {
+		positive.Detail = "amount must be greater than zero"
+	}
+	checks = append(checks, positive)
+
+	if vm.MinAmount > 0 {
+		minimum := CheckResult{Name: "minimum amount", Passed: tx.Amount >= vm.MinAmount}
+		if minimum.Passed {
+			minimum.Detail = fmt.Sprintf("at least %s", vm.FormatAmount(vm.MinAmount))
+		} else {
+			minimum.Detail = fmt.Sprintf("amount is below the minimum of %s", vm.FormatAmount(vm.MinAmount))
+		}
+		checks = append(checks, minimum)
+	}
+
+	if tx.Quote != nil {
+		quote := CheckResult{Name: "oracle quote", Passed: tx.Quote.Verify(vm.OraclePublicKey)}
+		if quote.Passed {
+			quote.Detail = fmt.Sprintf("%s at %f signed by the trusted oracle", tx.Quote.Asset, tx.Quote.Price)
+		} else {
+			quote.Detail = "quote is not signed by the trusted oracle"
+		}
+		checks = append(checks, quote)
+	}
+
+	distinct := CheckResult{Name: "not self-transfer", Passed: tx.Sender != tx.Receiver}
+	if distinct.Passed {
+		distinct.Detail = "sender and receiver differ"
+	} else {
+		distinct.Detail = "sender and receiver are the same account"
+	}
+	return append(checks, distinct)
+}
+
+// ReplayResult is a transaction that fails the rules of the VM it was replayed against
+type ReplayResult struct {
+	Height int
+	Tx     *Transaction
+	Reason string
+}
+
+// ReplayChain checks every value transfer in bc against this VM's rules, such
+// as MinAmount, and returns those that would now be rejected. Neither bc nor
+// the VM is modified, so a freshly configured VM can be used for what-if analysis.
+func (vm *VirtualMachine) ReplayChain(bc *Blockchain) []ReplayResult {
+	var invalid []ReplayResult
+	for height, block := range bc.Blocks {
+		for _, tx := range block.Transactions {
+			if tx.IsData() {
+				continue
+			}
+			for _, check := range vm.ruleChecks(tx) {
+				if !check.Passed {
+					invalid = append(invalid, ReplayResult{
+						Height: height,
+						Tx:     tx,
+						Reason: fmt.Sprintf("%s: %s", check.Name, check.Detail),
+					})
+					break
+				}
+			}
+		}
+	}
+	return invalid
+}
+
+// ValidateTransaction returns an error describing the first check in ExplainValidation that tx fails
+func (vm *VirtualMachine) ValidateTransaction(tx *Transaction) error {
+	for _, check := range vm.ExplainValidation(tx) {
+		if !check.Passed {
+			return fmt.Errorf("%s: %s", check.Name, check.Detail)
+		}
+	}
+	return nil
+}
+
+// RecordRejection appends a rejected transaction and its reason to the dead-letter sink, if one is set
+func (vm *VirtualMachine) RecordRejection(sender, receiver, amount, reason string) {
+	if vm.DeadLetter == nil {
+		return
+	}
+	data, err := json.Marshal(RejectedTransaction{
+		Timestamp: vm.clock.Now(),
+		Sender:    sender,
+		Receiver:  receiver,
+		Amount:    amount,
+		Reason:    reason,
+	})
+	if err != nil {
+		fmt.Println("Failed to encode rejected transaction:", err)
+		return
+	}
+	if _, err := fmt.Fprintln(vm.DeadLetter, string(data)); err != nil {
+		fmt.Println("Failed to write dead-letter record:", err)
+	}
+}
+
+// Benchmark populates the chain with numAccounts accounts named bench0..benchN-1
+// (reusing any that exist) and numTx random transfers between them, one block
+// per transfer as with send, and returns how long the run took
+func (vm *VirtualMachine) Benchmark(numAccounts, numTx int) (time.Duration, error) {
+	if numAccounts < 2 {
+		return 0, errors.New("benchmark needs at least 2 accounts")
+	}
+	if numTx < 0 {
+		return 0, errors.New("number of transactions must not be negative")
+	}
+	if err := vm.checkWritable(); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	accounts := make([]*Account, 0, numAccounts)
+	for i := 0; i < numAccounts; i++ {
+		username := fmt.Sprintf("bench%d", i)
+		account := vm.GetAccount(username)
+		if account == nil {
+			var err error
+			if account, err = vm.CreateAccount(username); err != nil {
+				return 0, err
+			}
+		}
+		accounts = append(accounts, account)
+	}
+	for i := 0; i < numTx; i++ {
+		s := rand.Intn(len(accounts))
+		r := rand.Intn(len(accounts) - 1)
+		if r >= s {
+			r++
+		}
+		tx := NewTransaction(accounts[s], accounts[r], float64(rand.Intn(100)+1))
+		if err := vm.ValidateTransaction(tx); err != nil {
+			return 0, fmt.Errorf("benchmark transfer rejected: %w", err)
+		}
+		if err := vm.AddBlockToChain([]*Transaction{tx}); err != nil {
+			return 0, err
+		}
+	}
+	return time.Since(start), nil
+}
+
+// GetAccount retrieves an account by username
+func (vm *VirtualMachine) GetAccount(username string) *Account {
+	return vm.Accounts[vm.normalizeUsername(username)]
+}
+
+// SetAlias makes name a shorthand for the registered account target. A real
+// username always takes precedence over an alias, so name may not already be
+// a username; if an account called name is created later, it wins.
+func (vm *VirtualMachine) SetAlias(name, target string) error {
+	if err := vm.checkWritable(); err != nil {
+		return err
+	}
+	if vm.GetAccount(name) != nil {
+		return fmt.Errorf("%s is already a username and cannot be an alias", name)
+	}
+	account := vm.GetAccount(target)
+	if account == nil {
+		return fmt.Errorf("alias target %s is not a registered account", target)
+	}
+	vm.aliases[name] = account.Username
+	return nil
+}
+
+// ResolveAlias returns the username that name refers to: name itself if it is
+// a username or unknown, otherwise the username its alias points at
+func (vm *VirtualMachine) ResolveAlias(name string) string {
+	if vm.GetAccount(name) != nil {
+		return name
+	}
+	if target, ok := vm.aliases[name]; ok {
+		return target
+	}
+	return name
+}
+
+// lookupAccount returns the account named by a username or alias, or nil
+func (vm *VirtualMachine) lookupAccount(name string) *Account {
+	return vm.GetAccount(vm.ResolveAlias(name))
+}
+
+// normalizeUsername maps username to the form it is stored under, per the username policy
+func (vm *VirtualMachine) normalizeUsername(username string) string {
+	if vm.UsernamePolicy.CaseSensitive {
+		return username
+	}
+	return strings.ToLower(username)
+}
+
+// FindTransactions returns every mined transaction whose amount lies within
+// the inclusive range [minAmount, maxAmount]
+func (vm *VirtualMachine) FindTransactions(minAmount, maxAmount float64) []*Transaction {
+	var matches []*Transaction
+	for _, block := range vm.Blockchain.Blocks {
+		for _, tx := range block.Transactions {
+			if !tx.IsData() && tx.Amount >= minAmount && tx.Amount <= maxAmount {
+				matches = append(matches, tx)
+			}
+		}
+	}
+	return matches
+}
+
+// TransactionsBetween returns every mined transfer between accounts a and b,
+// in either direction, in chain order
+func (vm *VirtualMachine) TransactionsBetween(a, b string) []*Transaction {
+	var matches []*Transaction
+	for _, block := range vm.Blockchain.Blocks {
+		for _, tx := range block.Transactions {
+			if tx.IsData() {
+				continue
+			}
+			if (tx.Sender.Username == a && tx.Receiver.Username == b) ||
+				(tx.Sender.Username == b && tx.Receiver.Username == a) {
+				matches = append(matches, tx)
+			}
+		}
+	}
+	return matches
+}
+
+// findTransaction returns the first mined transaction with txID and its block height, or nil and -1
+func (vm *VirtualMachine) findTransaction(txID string) (*Transaction, int) {
+	for i, block := range vm.Blockchain.Blocks {
+		for _, tx := range block.Transactions {
+			if tx.ID == txID {
+				return tx, i
+			}
+		}
+	}
+	return nil, -1
+}
+
+// transactionHeight returns the height of the block holding tx, or -1 if it is not on the chain
+func (vm *VirtualMachine) transactionHeight(tx *Transaction) int {
+	for i, block := range vm.Blockchain.Blocks {
+		for _, candidate := range block.Transactions {
+			if candidate == tx {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// TipInfo returns the height, hash and timestamp of the latest block
+func (vm *VirtualMachine) TipInfo() TipInfo {
+	height := len(vm.Blockchain.Blocks) - 1
+	tip := vm.Blockchain.Blocks[height]
+	return TipInfo{Height: height, Hash: tip.Hash, Timestamp: tip.Timestamp}
+}
+
+// ChangesSince returns every credit and debit to username from transactions in
+// blocks after height, in chain order, so a wallet can update incrementally
+func (vm *VirtualMachine) ChangesSince(username string, height int) []BalanceChange {
+	var changes []BalanceChange
+	start := height + 1
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < len(vm.Blockchain.Blocks); i++ {
+		for _, tx := range vm.Blockchain.Blocks[i].Transactions {
+			if tx.IsData() {
+				continue
+			}
+			if tx.Sender.Username == username {
+				changes = append(changes, BalanceChange{Height: i, TxID: tx.ID, Delta: -tx.Amount})
+			}
+			if tx.Receiver.Username == username {
+				changes = append(changes, BalanceChange{Height: i, TxID: tx.ID, Delta: tx.Amount})
+			}
+		}
+	}
+	return changes
+}
+
+// BalanceDelta returns the net change to username's balance from transfers in
+// blocks timestamped within [from, to]
+func (vm *VirtualMachine) BalanceDelta(username string, from, to time.Time) (float64, error) {
+	if vm.GetAccount(username) == nil {
+		return 0, fmt.Errorf("account %s not found", username)
+	}
+	if from.After(to) {
+		return 0, fmt.Errorf("start time %s is after end time %s", vm.FormatTime(from), vm.FormatTime(to))
+	}
+	var delta float64
+	for _, block := range vm.Blockchain.Blocks {
+		if block.Timestamp.Before(from) || block.Timestamp.After(to) {
+			continue
+		}
+		for _, tx := range block.Transactions {
+			if tx.IsData() {
+				continue
+			}
+			if tx.Sender.Username == username {
+				delta -= tx.Amount
+			}
+			if tx.Receiver.Username == username {
+				delta += tx.Amount
+			}
+		}
+	}
+	return delta, nil
+}
+
+// ReportByCategory sums the amounts of mined transfers per category;
+// uncategorized transfers are totalled under ""
+func (vm *VirtualMachine) ReportByCategory() map[string]float64 {
+	totals := make(map[string]float64)
+	for _, block := range vm.Blockchain.Blocks {
+		for _, tx := range block.Transactions {
+			if !tx.IsData() {
+				totals[tx.Category] += tx.Amount
+			}
+		}
+	}
+	return totals
+}
+
+// AverageAmount returns the mean amount of the value transfers in blocks
+// fromHeight to toHeight inclusive, or 0 if the range holds no transfers
+func (vm *VirtualMachine) AverageAmount(fromHeight, toHeight int) (float64, error) {
+	blocks, err := vm.Blockchain.BlocksInRange(fromHeight, toHeight)
+	if err != nil {
+		return 0, err
+	}
+	total, count := 0.0, 0
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			if tx.IsData() {
+				continue
+			}
+			total += tx.Amount
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	return total / float64(count), nil
+}
+
+// Provenance traces where the funds spent by txID came from. For each traced
+// transfer it takes the sender's most recent earlier incoming transfers until
+// they cover the amount, then repeats for those, up to ProvenanceDepth hops.
+// This is a simplified heuristic rather than exact fund tracking.
+func (vm *VirtualMachine) Provenance(txID string) ([]*Transaction, error) {
+	target, _ := vm.findTransaction(txID)
+	if target == nil {
+		return nil, fmt.Errorf("transaction %s not found", txID)
+	}
+	if target.IsData() {
+		return nil, errors.New("data transactions carry no funds")
+	}
+	var ordered []*Transaction
+	for _, block := range vm.Blockchain.Blocks {
+		ordered = append(ordered, block.Transactions...)
+	}
+	position := make(map[*Transaction]int, len(ordered))
+	for i, tx := range ordered {
+		position[tx] = i
+	}
+
+	var path []*Transaction
+	seen := map[*Transaction]bool{target: true}
+	frontier := []*Transaction{target}
+	for depth := 0; depth < vm.ProvenanceDepth && len(frontier) > 0; depth++ {
+		var next []*Transaction
+		for _, spent := range frontier {
+			covered := 0.0
+			for i := position[spent] - 1; i >= 0 && covered < spent.Amount; i-- {
+				funding := ordered[i]
+				if funding.IsData() || funding.Receiver != spent.Sender || seen[funding] {
+					continue
+				}
+				seen[funding] = true
+				covered += funding.Amount
+				path = append(path, funding)
+				next = append(next, funding)
+			}
+		}
+		frontier = next
+	}
+	return path, nil
+}
+
+// ShortHash truncates hash to DisplayHashLen hex digits for display
+func (vm *VirtualMachine) ShortHash(hash string) string {
+	if vm.DisplayHashLen <= 0 || len(hash) <= vm.DisplayHashLen {
+		return hash
+	}
+	return hash[:vm.DisplayHashLen]
+}
+
+// RoundAmount rounds amount to DisplayDecimals places using RoundingMode. The
+// amount is taken as its shortest decimal form, so 0.125 is a true half.
+func (vm *VirtualMachine) RoundAmount(amount float64) float64 {
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return amount
+	}
+	exact, _ := new(big.Rat).SetString(strconv.FormatFloat(amount, 'f', -1, 64))
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(vm.DisplayDecimals)), nil)
+	quotient, remainder := new(big.Int).QuoRem(new(big.Int).Mul(exact.Num(), scale), exact.Denom(), new(big.Int))
+	// Twice the discarded part against the denominator tells below, at or above half
+	half := new(big.Int).Lsh(new(big.Int).Abs(remainder), 1).Cmp(exact.Denom())
+	var away bool
+	switch vm.RoundingMode {
+	case RoundHalfUp:
+		away = half >= 0
+	case RoundHalfEven:
+		away = half > 0 || (half == 0 && quotient.Bit(0) == 1)
+	}
+	if away && remainder.Sign() != 0 {
+		quotient.Add(quotient, big.NewInt(int64(remainder.Sign())))
+	}
+	rounded, _ := new(big.Rat).SetFrac(quotient, scale).Float64()
+	return rounded
+}
+
+// FormatAmount renders amount with DisplayDecimals places, rounded per RoundingMode
+func (vm *VirtualMachine) FormatAmount(amount float64) string {
+	return strconv.FormatFloat(vm.RoundAmount(amount), 'f', vm.DisplayDecimals, 64)
+}
+
+// formatDelta renders a balance change like FormatAmount but always with a sign
+func (vm *VirtualMachine) formatDelta(delta float64) string {
+	formatted := vm.FormatAmount(delta)
+	if !strings.HasPrefix(formatted, "-") {
+		formatted = "+" + formatted
+	}
+	return formatted
+}
+
+// FormatTime renders t with TimeFormat, without any monotonic clock reading
+func (vm *VirtualMachine) FormatTime(t time.Time) string {
+	layout := vm.TimeFormat
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	return t.Round(0).Format(layout)
+}
+
+// BlockByHash returns the block whose hash is, or starts with, prefix along
+// with its height. It fails if no block or more than one block matches.
+func (vm *VirtualMachine) BlockByHash(prefix string) (*Block, int, error) {
+	if prefix == "" {
+		return nil, -1, errors.New("empty block hash")
+	}
+	var found *Block
+	height := -1
+	for i, block := range vm.Blockchain.Blocks {
+		if !strings.HasPrefix(block.Hash, prefix) {
+			continue
+		}
+		if found != nil {
+			return nil, -1, fmt.Errorf("block hash prefix %s is ambiguous", prefix)
+		}
+		found, height = block, i
+	}
+	if found == nil {
+		return nil, -1, fmt.Errorf("no block with hash %s", prefix)
+	}
+	return found, height, nil
+}
+
+// ResolveTransactionID expands a full or unambiguous short transaction ID to the full ID
+func (vm *VirtualMachine) ResolveTransactionID(prefix string) (string, error) {
+	if prefix == "" {
+		return "", errors.New("empty transaction ID")
+	}
+	match := ""
+	for _, block := range vm.Blockchain.Blocks {
+		for _, tx := range block.Transactions {
+			if !strings.HasPrefix(tx.ID, prefix) || tx.ID == match {
+				continue
+			}
+			if match != "" {
+				return "", fmt.Errorf("transaction ID prefix %s is ambiguous", prefix)
+			}
+			match = tx.ID
+		}
+	}
+	if match == "" {
+		return "", fmt.Errorf("transaction %s not found", prefix)
+	}
+	return match, nil
+}
+
+// ChainView returns a typed snapshot of the chain, separating the data from how it is printed
+func (vm *VirtualMachine) ChainView() []BlockView {
+	views := make([]BlockView, 0, len(vm.Blockchain.Blocks))
+	for i, block := range vm.Blockchain.Blocks {
+		view := BlockView{
+			Height:       i,
+			Hash:         block.Hash,
+			PrevHash:     block.PrevBlockHash,
+			Timestamp:    block.Timestamp,
+			Message:      block.Message,
+			Transactions: make([]TransactionView, 0, len(block.Transactions)),
+		}
+		for _, tx := range block.Transactions {
+			if tx.IsData() {
+				view.Transactions = append(view.Transactions, TransactionView{
+					ID:   tx.ID,
+					Data: hex.EncodeToString(tx.Data),
+				})
+				continue
+			}
+			view.Transactions = append(view.Transactions, TransactionView{
+				ID:       tx.ID,
+				From:     tx.Sender.Username,
+				FromName: tx.Sender.DisplayName,
+				To:       tx.Receiver.Username,
+				ToName:   tx.Receiver.DisplayName,
+				Amount:   tx.Amount,
+				Category: tx.Category,
+			})
+		}
+		views = append(views, view)
+	}
+	return views
+}
+
+// InactiveAccounts returns, sorted, the usernames of accounts that have never
+// sent or received a mined transaction
+func (vm *VirtualMachine) InactiveAccounts() []string {
+	active := make(map[string]bool)
+	for _, block := range vm.Blockchain.Blocks {
+		for _, tx := range block.Transactions {
+			if tx.IsData() {
+				continue
+			}
+			active[tx.Sender.Username] = true
+			active[tx.Receiver.Username] = true
+		}
+	}
+	var inactive []string
+	for username := range vm.Accounts {
+		if !active[username] {
+			inactive = append(inactive, username)
+		}
+	}
+	sort.Strings(inactive)
+	return inactive
+}
+
+// MostActiveAccount returns the username involved, as sender or receiver, in
+// the most mined transfers and that count. Ties go to the lexicographically
+// smallest username; a chain without transfers returns "", 0.
+func (vm *VirtualMachine) MostActiveAccount() (string, int) {
+	counts := make(map[string]int)
+	for _, block := range vm.Blockchain.Blocks {
+		for _, tx := range block.Transactions {
+			if tx.IsData() {
+				continue
+			}
+			counts[tx.Sender.Username]++
+			if tx.Receiver.Username != tx.Sender.Username {
+				counts[tx.Receiver.Username]++
+			}
+		}
+	}
+	best, bestCount := "", 0
+	for username, count := range counts {
+		if count > bestCount || (count == bestCount && username < best) {
+			best, bestCount = username, count
+		}
+	}
+	return best, bestCount
+}
+
+// PruneInactiveAccounts removes every account reported by InactiveAccounts and returns their usernames
+func (vm *VirtualMachine) PruneInactiveAccounts() ([]string, error) {
+	if err := vm.checkWritable(); err != nil {
+		return nil, err
+	}
+	inactive := vm.InactiveAccounts()
+	for _, username := range inactive {
+		delete(vm.Accounts, username)
+	}
+	return inactive, nil
+}
+
+// SetDisplayName sets a human-readable name for an account. The username stays
+// the account's identity, so hashes are unaffected and names need not be unique.
+func (vm *VirtualMachine) SetDisplayName(username, name string) error {
+	if err := vm.checkWritable(); err != nil {
+		return err
+	}
+	account := vm.lookupAccount(username)
+	if account == nil {
+		return fmt.Errorf("account %s does not exist", username)
+	}
+	account.DisplayName = name
+	return nil
+}
+
+func main() {
+	configPath := flag.String("config", "", "JSON file of VM settings; flags given explicitly override it")
+	deadLetterPath := flag.String("dead-letter", "", "append rejected transactions as JSON lines to this file")
+	genesisMessage := flag.String("genesis-message", "", "text to embed in the genesis block")
+	deterministic := flag.Bool("deterministic", false, "use block heights as timestamps for reproducible hashes")
+	trustImported := flag.Bool("trust-imported", false, "skip proof-of-work checks for chains loaded from file")
+	timeFormat := flag.String("time-format", time.RFC3339, "Go time layout used to print timestamps")
+	strict := flag.Bool("strict", false, "exit with a non-zero status on the first unknown or failing command")
+	flag.Parse()
+
+	config := DefaultConfig()
+	if *configPath != "" {
+		var err error
+		if config, err = LoadConfig(*configPath); err != nil {
+			fmt.Println("Failed to load config:", err)
+			os.Exit(1)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dead-letter":
+			config.DeadLetter = *deadLetterPath
+		case "genesis-message":
+			config.GenesisMessage = *genesisMessage
+		case "deterministic":
+			config.Deterministic = *deterministic
+		case "trust-imported":
+			config.TrustImported = *trustImported
+		case "time-format":
+			config.TimeFormat = *timeFormat
+		}
+	})
+
+	vm := NewVirtualMachineFromConfig(config)
+	if config.DeadLetter != "" {
+		deadLetter, err := os.OpenFile(config.DeadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Println("Failed to open dead-letter file:", err)
+			os.Exit(1)
+		}
+		defer deadLetter.Close()
+		vm.DeadLetter = deadLetter
+	}
+
+	if err := runLoop(vm, os.Stdin, *strict); err != nil {
+		fmt.Println("Error:", err)
+		if closer, ok := vm.DeadLetter.(io.Closer); ok {
+			closer.Close()
+		}
+		os.Exit(1)
+	}
+}
+
+// runLoop reads commands from input and runs them until exit or end of
+// input. In strict mode the first unknown or failing command stops the loop
+// and its error is returned, so scripted runs can fail fast.
+func runLoop(vm *VirtualMachine, input io.Reader, strict bool) error {
+	reader := bufio.NewReader(input)
+	for {
+		fmt.Println("\nCommands:")
+		fmt.Println("1. create_account [username]")
+		fmt.Println("2. create_accounts [prefix] [count]")
+		fmt.Println("3. send [sender] [receiver] [amount] [--category name]")
+		fmt.Println("4. view_blockchain [--json|--full] [--out path]")
+		fmt.Println("5. set_name [username] [display name]")
+		fmt.Println("6. find [min] [max]")
+		fmt.Println("7. inactive [--prune]")
+		fmt.Println("8. dump [height|block hash] [--out path]")
+		fmt.Println("9. swap [a] [b] [amount a->b] [amount b->a]")
+		fmt.Println("10. benchmark [numAccounts] [numTx] (adds accounts and blocks to the chain)")
+		fmt.Println("11. watch [txid] [confirmations]")
+		fmt.Println("12. anchor [hexdata]")
+		fmt.Println("13. tip")
+		fmt.Println("14. cost [sender] [receiver] [amount] | cost --data [hexdata]")
+		fmt.Println("15. changes [username] [since height]")
+		fmt.Println("16. compact [maxTxPerBlock] (rewrites the chain)")
+		fmt.Println("17. validate_tx [sender] [receiver] [amount]")
+		fmt.Println("18. avg [from height] [to height]")
+		fmt.Println("19. save [path] [json|gob]")
+		fmt.Println("20. load [path] [json|gob]")
+		fmt.Println("21. export_dot [path]")
+		fmt.Println("22. longest_gap")
+		fmt.Println("23. verify_file [path]")
+		fmt.Println("24. provenance [txid]")
+		fmt.Println("25. digest")
+		fmt.Println("26. alias [name] [target]")
+		fmt.Println("27. replay [min amount]")
+		fmt.Println("28. between [a] [b]")
+		fmt.Println("29. tx_counts [--transfers]")
+		fmt.Println("30. most_active")
+		fmt.Println("31. delta [username] [from RFC3339] [to RFC3339]")
+		fmt.Println("32. report_categories")
+		fmt.Println("33. blocks_between [from RFC3339] [to RFC3339]")
+		fmt.Println("34. check_unique")
+		fmt.Println("35. maintenance [on|off]")
+		fmt.Println("36. exit")
+
+		fmt.Print("Enter command: ")
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if err != nil && line == "" {
+			// Input ended (e.g. a pipe without a trailing "exit"); any final
+			// unterminated command was already run on the previous pass
+			if err != io.EOF {
+				fmt.Println("\nFailed to read command:", err)
+			}
+			fmt.Println("\nEnd of input. Exiting...")
+			if err != io.EOF {
+				return err
+			}
+			return nil
+		}
+
+		for _, command := range splitCommands(line) {
+			exit, err := runCommand(vm, command)
+			if err != nil && strict {
+				return fmt.Errorf("command %q failed: %w", command, err)
+			}
+			if exit {
+				return nil
+			}
+		}
+	}
+}
+
+// splitCommands breaks an input line into the commands to run: commands are
+// separated by ';', a line starting with '#' is a comment in its entirety,
+// and so is any single command starting with '#'
+func splitCommands(line string) []string {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return nil
+	}
+	var commands []string
+	for _, command := range strings.Split(line, ";") {
+		command = strings.TrimSpace(command)
+		if command == "" || strings.HasPrefix(command, "#") {
+			continue
+		}
+		commands = append(commands, command)
+	}
+	return commands
+}
+
+// runCommand executes a single command against vm. It reports whether the
+// program should exit, and returns an error if the command was unknown or failed.
+func runCommand(vm *VirtualMachine, command string) (bool, error) {
+	parts := strings.Split(command, " ")
+
+	// fail prints a failure message and records it as the command's error
+	var failure error
+	fail := func(a ...any) {
+		message := strings.TrimSuffix(fmt.Sprintln(a...), "\n")
+		fmt.Println(message)
+		failure = errors.New(message)
+	}
+
+	switch parts[0] {
+	case "create_account":
+		if len(parts) != 2 {
+			fail("Usage: create_account [username]")
+		} else if _, err := vm.CreateAccount(parts[1]); err != nil {
+			fail(err)
+		}
+
+	case "create_accounts":
+		if len(parts) != 3 {
+			fail("Usage: create_accounts [prefix] [count]")
+			break
+		}
+		count, err := strconv.Atoi(parts[2])
+		if err != nil || count < 0 {
+			fail("Invalid count.")
+			break
+		}
+		created, err := vm.CreateAccounts(parts[1], count)
+		fmt.Printf("Created %d of %d accounts.\n", len(created), count)
+		if err != nil {
+			fail(err)
+		}
+
+	case "send":
+		if len(parts) != 4 && (len(parts) != 6 || parts[4] != "--category" || parts[5] == "") {
+			fail("Usage: send [sender] [receiver] [amount] [--category name]")
+		} else {
+			sender := vm.lookupAccount(parts[1])
+			receiver := vm.lookupAccount(parts[2])
+			if sender == nil || receiver == nil {
+				fail("Invalid sender or receiver.")
+				vm.RecordRejection(parts[1], parts[2], parts[3], "invalid sender or receiver")
+				break
+			}
+			amount, err := vm.ParseAmount(parts[3])
+			if err != nil {
+				fail(err)
+				vm.RecordRejection(parts[1], parts[2], parts[3], err.Error())
+				break
+			}
+			tx := NewTransaction(sender, receiver, amount)
+			if len(parts) == 6 {
+				tx = NewCategorizedTransaction(sender, receiver, amount, parts[5])
+			}
+			if err := vm.ValidateTransaction(tx); err != nil {
+				fail("Transaction rejected:", err)
+				vm.RecordRejection(parts[1], parts[2], parts[3], err.Error())
+				break
+			}
+			if err := vm.AddBlockToChain([]*Transaction{tx}); err != nil {
+				fail(err)
+				vm.RecordRejection(parts[1], parts[2], parts[3], err.Error())
+			}
+		}
+
+	case "view_blockchain":
+		args, outPath, err := splitOutFlag(parts[1:])
+		if err != nil || len(args) > 1 || (len(args) == 1 && args[0] != "--json" && args[0] != "--full") {
+			fail("Usage: view_blockchain [--json|--full] [--out path]")
+			break
+		}
+		err = withOutput(outPath, func(w io.Writer) error {
+			if len(args) == 1 && args[0] == "--json" {
+				return viewBlockchainJSON(vm, w)
+			}
+			viewBlockchain(vm, w, len(args) == 1 && args[0] == "--full")
+			return nil
+		})
+		if err != nil {
+			fail("Failed to write blockchain:", err)
+			break
+		}
+		if outPath != "" {
+			fmt.Printf("Wrote blockchain to %s.\n", outPath)
+		}
+
+	case "set_name":
+		if len(parts) < 3 {
+			fail("Usage: set_name [username] [display name]")
+		} else if err := vm.SetDisplayName(parts[1], strings.Join(parts[2:], " ")); err != nil {
+			fail(err)
+		} else {
+			fmt.Printf("Display name for %s set.\n", parts[1])
+		}
+
+	case "find":
+		if len(parts) != 3 {
+			fail("Usage: find [min] [max]")
+			break
+		}
+		minAmount, err1 := strconv.ParseFloat(parts[1], 64)
+		maxAmount, err2 := strconv.ParseFloat(parts[2], 64)
+		if err1 != nil || err2 != nil {
+			fail("Invalid amount.")
+			break
+		}
+		if minAmount > maxAmount {
+			fail("Minimum amount must not exceed maximum amount.")
+			break
+		}
+		for _, tx := range vm.FindTransactions(minAmount, maxAmount) {
+			fmt.Printf("  Block %d | TxID: %s | From: %s | To: %s | Amount: %s\n",
+				vm.transactionHeight(tx), vm.ShortHash(tx.ID), tx.Sender.Label(), tx.Receiver.Label(), vm.FormatAmount(tx.Amount))
+		}
+
+	case "inactive":
+		if len(parts) == 2 && parts[1] == "--prune" {
+			pruned, err := vm.PruneInactiveAccounts()
+			if err != nil {
+				fail(err)
+				break
+			}
+			for _, username := range pruned {
+				fmt.Printf("Pruned account: %s\n", username)
+			}
+			break
+		}
+		for _, username := range vm.InactiveAccounts() {
+			fmt.Printf("  %s\n", username)
+		}
+
+	case "dump":
+		args, outPath, err := splitOutFlag(parts[1:])
+		if err != nil || len(args) != 1 {
+			fail("Usage: dump [height|block hash] [--out path]")
+			break
+		}
+		// A number within the chain is a height; anything else is a hash prefix
+		height, err := strconv.Atoi(args[0])
+		if err != nil || height < 0 || height >= len(vm.Blockchain.Blocks) {
+			if _, height, err = vm.BlockByHash(args[0]); err != nil {
+				fail(err)
+				break
+			}
+		}
+		if err := withOutput(outPath, func(w io.Writer) error { return dumpBlock(vm, w, height) }); err != nil {
+			fail("Failed to write block:", err)
+			break
+		}
+		if outPath != "" {
+			fmt.Printf("Wrote block %d to %s.\n", height, outPath)
+		}
+
+	case "swap":
+		if len(parts) != 5 {
+			fail("Usage: swap [a] [b] [amount a->b] [amount b->a]")
+			break
+		}
+		// reject records both legs of a refused swap in the dead-letter sink
+		reject := func(reason string) {
+			vm.RecordRejection(parts[1], parts[2], parts[3], "swap rejected: "+reason)
+			vm.RecordRejection(parts[2], parts[1], parts[4], "swap rejected: "+reason)
+		}
+		a := vm.lookupAccount(parts[1])
+		b := vm.lookupAccount(parts[2])
+		if a == nil || b == nil {
+			fail("Invalid sender or receiver.")
+			reject("invalid sender or receiver")
+			break
+		}
+		amountAB, err := vm.ParseAmount(parts[3])
+		if err != nil {
+			fail(err)
+			reject(err.Error())
+			break
+		}
+		amountBA, err := vm.ParseAmount(parts[4])
+		if err != nil {
+			fail(err)
+			reject(err.Error())
+			break
+		}
+		if err := vm.ExecuteSwap(NewAtomicSwap(a, b, amountAB, amountBA)); err != nil {
+			fail("Swap rejected:", err)
+			reject(err.Error())
+		}
+
+	case "benchmark":
+		if len(parts) != 3 {
+			fail("Usage: benchmark [numAccounts] [numTx]")
+			break
+		}
+		numAccounts, err1 := strconv.Atoi(parts[1])
+		numTx, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil {
+			fail("Invalid number.")
+			break
+		}
+		elapsed, err := vm.Benchmark(numAccounts, numTx)
+		if err != nil {
+			fail(err)
+			break
+		}
+		fmt.Printf("Benchmark added %d transactions to the chain in %s (%.2f tx/sec)\n",
+			numTx, elapsed, float64(numTx)/elapsed.Seconds())
+
+	case "watch":
+		if len(parts) != 3 {
+			fail("Usage: watch [txid] [confirmations]")
+			break
+		}
+		confirmations, err := strconv.Atoi(parts[2])
+		if err != nil || confirmations < 1 {
+			fail("Invalid number of confirmations.")
+			break
+		}
+		// A mined transaction may be named by a short prefix; one not yet mined
+		// can only be watched by its full ID
+		txID, err := vm.ResolveTransactionID(parts[1])
+		if err != nil {
+			if len(parts[1]) != sha256.Size*2 {
+				fail(err)
+				break
+			}
+			txID = parts[1]
+			fmt.Printf("Transaction %s is not on the chain yet; watching for it.\n", vm.ShortHash(txID))
+		}
+		vm.WatchTransaction(txID, confirmations, func(tx *Transaction) {
+			fmt.Printf("Transaction %s reached %d confirmations.\n", tx.ID, confirmations)
+		})
+
+	case "anchor":
+		if len(parts) != 2 {
+			fail("Usage: anchor [hexdata]")
+			break
+		}
+		data, err := hex.DecodeString(strings.TrimPrefix(parts[1], "0x"))
+		if err != nil {
+			fail("Invalid hex data.")
+			break
+		}
+		tx, err := NewDataTransaction(data)
+		if err != nil {
+			fail(err)
+			break
+		}
+		if err := vm.AddBlockToChain([]*Transaction{tx}); err != nil {
+			fail(err)
+		}
+
+	case "tip":
+		tip := vm.TipInfo()
+		fmt.Printf("Height: %d\n", tip.Height)
+		fmt.Printf("Hash: %s\n", tip.Hash)
+		fmt.Printf("Timestamp: %s\n", vm.FormatTime(tip.Timestamp))
+
+	case "cost":
+		var tx *Transaction
+		if len(parts) == 3 && parts[1] == "--data" {
+			data, err := hex.DecodeString(strings.TrimPrefix(parts[2], "0x"))
+			if err != nil {
+				fail("Invalid hex data.")
+				break
+			}
+			if tx, err = NewDataTransaction(data); err != nil {
+				fail(err)
+				break
+			}
+		} else if len(parts) == 4 {
+			sender := vm.lookupAccount(parts[1])
+			receiver := vm.lookupAccount(parts[2])
+			if sender == nil || receiver == nil {
+				fail("Invalid sender or receiver.")
+				break
+			}
+			amount, err := vm.ParseAmount(parts[3])
+			if err != nil {
+				fail(err)
+				break
+			}
+			tx = NewTransaction(sender, receiver, amount)
+		} else {
+			fail("Usage: cost [sender] [receiver] [amount] | cost --data [hexdata]")
+			break
+		}
+		fmt.Printf("Estimated cost: %d\n", EstimateTxCost(tx))
+
+	case "changes":
+		if len(parts) != 3 {
+			fail("Usage: changes [username] [since height]")
+			break
+		}
+		account := vm.lookupAccount(parts[1])
+		if account == nil {
+			fail("Invalid username.")
+			break
+		}
+		since, err := strconv.Atoi(parts[2])
+		if err != nil {
+			fail("Invalid height.")
+			break
+		}
+		for _, change := range vm.ChangesSince(account.Username, since) {
+			fmt.Printf("  Block %d | TxID: %s | Delta: %s\n", change.Height, vm.ShortHash(change.TxID), vm.formatDelta(change.Delta))
+		}
+
+	case "delta":
+		if len(parts) != 4 {
+			fail("Usage: delta [username] [from RFC3339] [to RFC3339]")
+			break
+		}
+		from, err1 := time.Parse(time.RFC3339, parts[2])
+		to, err2 := time.Parse(time.RFC3339, parts[3])
+		if err1 != nil || err2 != nil {
+			fail("Invalid time; use RFC3339, e.g. 2024-01-02T15:04:05Z.")
+			break
+		}
+		account := vm.lookupAccount(parts[1])
+		if account == nil {
+			fail("Invalid username.")
+			break
+		}
+		delta, err := vm.BalanceDelta(account.Username, from, to)
+		if err != nil {
+			fail(err)
+			break
+		}
+		fmt.Printf("Net change for %s: %s\n", account.Label(), vm.formatDelta(delta))
+
+	case "blocks_between":
+		if len(parts) != 3 {
+			fail("Usage: blocks_between [from RFC3339] [to RFC3339]")
+			break
+		}
+		from, err1 := time.Parse(time.RFC3339, parts[1])
+		to, err2 := time.Parse(time.RFC3339, parts[2])
+		if err1 != nil || err2 != nil {
+			fail("Invalid time; use RFC3339, e.g. 2024-01-02T15:04:05Z.")
+			break
+		}
+		if from.After(to) {
+			fail("Start time must not be after end time.")
+			break
+		}
+		blocks := vm.Blockchain.BlocksBetweenTimes(from, to)
+		for _, block := range blocks {
+			_, height, _ := vm.BlockByHash(block.Hash)
+			fmt.Printf("  Block %d | Hash: %s | Timestamp: %s | Transactions: %d\n",
+				height, vm.ShortHash(block.Hash), vm.FormatTime(block.Timestamp), len(block.Transactions))
+		}
+		fmt.Printf("%d blocks in the window.\n", len(blocks))
+
+	case "compact":
+		if len(parts) != 2 {
+			fail("Usage: compact [maxTxPerBlock]")
+			break
+		}
+		maxTxPerBlock, err := strconv.Atoi(parts[1])
+		if err != nil || maxTxPerBlock < 1 {
+			fail("Invalid number of transactions per block.")
+			break
+		}
+		if err := vm.checkWritable(); err != nil {
+			fail(err)
+			break
+		}
+		before := len(vm.Blockchain.Blocks)
+		vm.Blockchain.Blocks = vm.Blockchain.CompactChain(maxTxPerBlock).Blocks
+		fmt.Printf("Compacted chain from %d to %d blocks.\n", before, len(vm.Blockchain.Blocks))
+
+	case "validate_tx":
+		if len(parts) != 4 {
+			fail("Usage: validate_tx [sender] [receiver] [amount]")
+			break
+		}
+		amount, err := vm.ParseAmount(parts[3])
+		if err != nil {
+			fail(err)
+			break
+		}
+		sender := vm.lookupAccount(parts[1])
+		if sender == nil {
+			sender = NewAccount(parts[1])
+		}
+		receiver := vm.lookupAccount(parts[2])
+		if receiver == nil {
+			receiver = NewAccount(parts[2])
+		}
+		for _, check := range vm.ExplainValidation(NewTransaction(sender, receiver, amount)) {
+			status := "PASS"
+			if !check.Passed {
+				status = "FAIL"
+			}
+			fmt.Printf("  [%s] %s: %s\n", status, check.Name, check.Detail)
+		}
+
+	case "avg":
+		if len(parts) != 3 {
+			fail("Usage: avg [from height] [to height]")
+			break
+		}
+		fromHeight, err1 := strconv.Atoi(parts[1])
+		toHeight, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil {
+			fail("Invalid height.")
+			break
+		}
+		average, err := vm.AverageAmount(fromHeight, toHeight)
+		if err != nil {
+			fail(err)
+			break
+		}
+		fmt.Printf("Average amount: %s\n", vm.FormatAmount(average))
+
+	case "save", "load":
+		if len(parts) < 2 || len(parts) > 3 {
+			fail(fmt.Sprintf("Usage: %s [path] [json|gob]", parts[0]))
+			break
+		}
+		codecName := "json"
+		if len(parts) == 3 {
+			codecName = parts[2]
+		}
+		codec, err := CodecByName(codecName)
+		if err != nil {
+			fail(err)
+			break
+		}
+		if parts[0] == "save" {
+			if err := vm.Blockchain.SaveToFile(parts[1], codec); err != nil {
+				fail("Failed to save chain:", err)
+				break
+			}
+			fmt.Printf("Saved %d blocks to %s.\n", len(vm.Blockchain.Blocks), parts[1])
+			break
+		}
+		bc, err := LoadFromFile(parts[1], codec)
+		if err == nil {
+			err = vm.LoadChain(bc)
+		}
+		if err != nil {
+			fail("Failed to load chain:", err)
+			break
+		}
+		fmt.Printf("Loaded %d blocks from %s.\n", len(bc.Blocks), parts[1])
+
+	case "export_dot":
+		if len(parts) != 2 {
+			fail("Usage: export_dot [path]")
+			break
+		}
+		file, err := os.Create(parts[1])
+		if err != nil {
+			fail("Failed to create file:", err)
+			break
+		}
+		err = vm.Blockchain.ExportDOT(file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			fail("Failed to export DOT:", err)
+			break
+		}
+		fmt.Printf("Exported %d blocks to %s.\n", len(vm.Blockchain.Blocks), parts[1])
+
+	case "longest_gap":
+		if len(vm.Blockchain.Blocks) < 2 {
+			fmt.Println("The chain has only a genesis block.")
+			break
+		}
+		start, end, duration := vm.Blockchain.LongestGap()
+		fmt.Printf("Longest gap: %s between block %d and block %d\n", duration, start, end)
+
+	case "verify_file":
+		if len(parts) != 2 {
+			fail("Usage: verify_file [path]")
+			break
+		}
+		if ok, err := VerifyChainFile(parts[1]); !ok {
+			fail("Chain file is invalid:", err)
+		} else {
+			fmt.Println("Chain file is valid.")
+		}
+
+	case "provenance":
+		if len(parts) != 2 {
+			fail("Usage: provenance [txid]")
+			break
+		}
+		txID, err := vm.ResolveTransactionID(parts[1])
+		if err != nil {
+			fail(err)
+			break
+		}
+		path, err := vm.Provenance(txID)
+		if err != nil {
+			fail(err)
+			break
+		}
+		if len(path) == 0 {
+			fmt.Println("No earlier incoming transfers funded this transaction.")
+		}
+		for _, tx := range path {
+			fmt.Printf("  Block %d | TxID: %s | From: %s | To: %s | Amount: %s\n",
+				vm.transactionHeight(tx), vm.ShortHash(tx.ID), tx.Sender.Label(), tx.Receiver.Label(), vm.FormatAmount(tx.Amount))
+		}
+
+	case "digest":
+		fmt.Printf("Chain digest: %s\n", vm.Blockchain.ChainDigest())
+
+	case "tx_counts":
+		transfersOnly := len(parts) == 2 && parts[1] == "--transfers"
+		for height, count := range vm.Blockchain.TxCountSeries(!transfersOnly) {
+			fmt.Printf("  Block %d | Transactions: %d\n", height, count)
+		}
+
+	case "most_active":
+		username, count := vm.MostActiveAccount()
+		if count == 0 {
+			fmt.Println("No transfers on the chain.")
+			break
+		}
+		fmt.Printf("Most active account: %s (%d transactions)\n", username, count)
+
+	case "report_categories":
+		totals := vm.ReportByCategory()
+		categories := make([]string, 0, len(totals))
+		for category := range totals {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+		for _, category := range categories {
+			name := category
+			if name == "" {
+				name = "(uncategorized)"
+			}
+			fmt.Printf("  %s: %s\n", name, vm.FormatAmount(totals[category]))
+		}
+
+	case "check_unique":
+		duplicates := vm.Blockchain.CheckUniqueTxIDs()
+		for _, txID := range duplicates {
+			fmt.Printf("  Duplicate TxID: %s\n", txID)
+		}
+		if len(duplicates) == 0 {
+			fmt.Println("All transaction IDs are unique.")
+		}
+
+	case "maintenance":
+		if len(parts) != 2 || (parts[1] != "on" && parts[1] != "off") {
+			fail("Usage: maintenance [on|off]")
+			break
+		}
+		if parts[1] == "on" {
+			vm.EnterMaintenance()
+			fmt.Println("Maintenance mode on: writes are paused.")
+		} else {
+			vm.ExitMaintenance()
+			fmt.Println("Maintenance mode off: writes resumed.")
+		}
+
+	case "alias":
+		if len(parts) != 3 {
+			fail("Usage: alias [name] [target]")
+			break
+		}
+		if err := vm.SetAlias(parts[1], parts[2]); err != nil {
+			fail(err)
+			break
+		}
+		fmt.Printf("Alias %s now refers to %s.\n", parts[1], vm.ResolveAlias(parts[1]))
+
+	case "replay":
+		if len(parts) != 2 {
+			fail("Usage: replay [min amount]")
+			break
+		}
+		minAmount, err := vm.ParseAmount(parts[1])
+		if err != nil {
+			fail(err)
+			break
+		}
+		rules := NewVirtualMachine()
+		rules.DisplayDecimals = vm.DisplayDecimals
+		rules.RoundingMode = vm.RoundingMode
+		rules.OraclePublicKey = vm.OraclePublicKey
+		rules.MinAmount = minAmount
+		invalid := rules.ReplayChain(vm.Blockchain)
+		for _, result := range invalid {
+			fmt.Printf("  Block %d | TxID: %s | %s\n", result.Height, vm.ShortHash(result.Tx.ID), result.Reason)
+		}
+		fmt.Printf("%d transactions would be rejected under the new rules.\n", len(invalid))
+
+	case "between":
+		if len(parts) != 3 {
+			fail("Usage: between [a] [b]")
+			break
+		}
+		a, b := vm.lookupAccount(parts[1]), vm.lookupAccount(parts[2])
+		if a == nil || b == nil {
+			fail("Invalid username.")
+			break
+		}
+		txs := vm.TransactionsBetween(a.Username, b.Username)
+		for _, tx := range txs {
+			fmt.Printf("  Block %d | TxID: %s | %s -> %s | Amount: %s\n",
+				vm.transactionHeight(tx), vm.ShortHash(tx.ID), tx.Sender.Label(), tx.Receiver.Label(), vm.FormatAmount(tx.Amount))
+		}
+		fmt.Printf("%d transactions between %s and %s.\n", len(txs), a.Label(), b.Label())
+
+	case "exit":
+		fmt.Println("Exiting...")
+		return true, nil
+
+	default:
+		fail("Unknown command")
+	}
+	return false, failure
+}
+
+// splitOutFlag removes a trailing "--out path" from a command's arguments,
+// returning the remaining arguments and the path, or "" if there is none
+func splitOutFlag(args []string) ([]string, string, error) {
+	for i, arg := range args {
+		if arg != "--out" {
+			continue
+		}
+		if i != len(args)-2 || args[i+1] == "" {
+			return nil, "", errors.New("--out must be followed by a path and come last")
+		}
+		return args[:i], args[i+1], nil
+	}
+	return args, "", nil
+}
+
+// withOutput calls write with stdout, or with the file at path (created or
+// truncated) when path is not empty
+func withOutput(path string, write func(io.Writer) error) error {
+	if path == "" {
+		return write(os.Stdout)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// viewBlockchain writes the entire blockchain to w, with hashes shortened unless full is set
+func viewBlockchain(vm *VirtualMachine, w io.Writer, full bool) {
+	hash := vm.ShortHash
+	if full {
+		hash = func(h string) string { return h }
+	}
+	for _, block := range vm.ChainView() {
+		fmt.Fprintf(w, "Block %d:\n", block.Height)
+		fmt.Fprintf(w, "Hash: %s\n", hash(block.Hash))
+		fmt.Fprintf(w, "Previous Hash: %s\n", hash(block.PrevHash))
+		fmt.Fprintf(w, "Timestamp: %s\n", vm.FormatTime(block.Timestamp))
+		if block.Message != "" {
+			fmt.Fprintf(w, "Message: %s\n", block.Message)
+		}
+		for _, tx := range block.Transactions {
+			if tx.Data != "" {
+				fmt.Fprintf(w, "  TxID: %s | Data: %s\n", hash(tx.ID), tx.Data)
+				continue
+			}
+			fmt.Fprintf(w, "  TxID: %s | From: %s | To: %s | Amount: %s",
+				hash(tx.ID), accountLabel(tx.From, tx.FromName), accountLabel(tx.To, tx.ToName), vm.FormatAmount(tx.Amount))
+			if tx.Category != "" {
+				fmt.Fprintf(w, " | Category: %s", tx.Category)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// viewBlockchainJSON writes the entire blockchain to w as indented JSON
+func viewBlockchainJSON(vm *VirtualMachine, w io.Writer) error {
+	data, err := json.MarshalIndent(vm.ChainView(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding blockchain: %w", err)
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// dumpBlock writes the block at height to w as JSON followed by the raw hash
+// preimage, so the stored hash can be checked by hand with any SHA-256 tool
+func dumpBlock(vm *VirtualMachine, w io.Writer, height int) error {
+	if height < 0 || height >= len(vm.Blockchain.Blocks) {
+		return fmt.Errorf("height %d out of range (0-%d)", height, len(vm.Blockchain.Blocks)-1)
+	}
+	data, err := json.MarshalIndent(vm.ChainView()[height], "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding block: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "%s\nHash preimage: %s\n", data, vm.Blockchain.Blocks[height].hashRecord())
+	return err
+}
+
+// Account represents a user account keyed by its username
+type Account struct {
+	Username    string
+	DisplayName string
+}
+
+// Label returns the account's display name alongside its username, or just
+// the username when no display name is set
+func (a *Account) Label() string {
+	return accountLabel(a.Username, a.DisplayName)
+}
+
+// accountLabel formats a username with its optional display name
+func accountLabel(username, displayName string) string {
+	if displayName == "" {
+		return username
+	}
+	return fmt.Sprintf("%s (%s)", displayName, username)
 }
 
 // NewAccount creates a new account with the given username
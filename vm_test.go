@@ -0,0 +1,1694 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEpoch is the fixed genesis time used by chains built in tests
+var testEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestVM returns a deterministic VM with the given accounts already created
+func newTestVM(t *testing.T, usernames ...string) *VirtualMachine {
+	t.Helper()
+	vm := NewVirtualMachineWithOptions(VMOptions{Deterministic: true})
+	for _, username := range usernames {
+		if _, err := vm.CreateAccount(username); err != nil {
+			t.Fatalf("CreateAccount(%q): %v", username, err)
+		}
+	}
+	return vm
+}
+
+// mustSend mines a single validated transfer and returns it
+func mustSend(t *testing.T, vm *VirtualMachine, from, to string, amount float64) *Transaction {
+	t.Helper()
+	tx := NewTransaction(vm.GetAccount(from), vm.GetAccount(to), amount)
+	if err := vm.ValidateTransaction(tx); err != nil {
+		t.Fatalf("send %s -> %s %v: %v", from, to, amount, err)
+	}
+	if err := vm.AddBlockToChain([]*Transaction{tx}); err != nil {
+		t.Fatalf("AddBlockToChain: %v", err)
+	}
+	return tx
+}
+
+func TestDiffChains(t *testing.T) {
+	alice, bob := NewAccount("alice"), NewAccount("bob")
+	build := func(amounts ...float64) *Blockchain {
+		bc := NewBlockchain(testEpoch)
+		for i, amount := range amounts {
+			bc.AddBlock([]*Transaction{NewTransaction(alice, bob, amount)}, testEpoch.Add(time.Duration(i+1)*time.Second))
+		}
+		return bc
+	}
+
+	common, aOnly, bOnly := DiffChains(build(1, 2), build(1, 2))
+	if common != 2 || len(aOnly) != 0 || len(bOnly) != 0 {
+		t.Errorf("identical chains: got common %d, %d/%d unique blocks", common, len(aOnly), len(bOnly))
+	}
+
+	common, aOnly, bOnly = DiffChains(build(1), build(1, 2, 3))
+	if common != 1 || len(aOnly) != 0 || len(bOnly) != 2 {
+		t.Errorf("prefix: got common %d, %d/%d unique blocks", common, len(aOnly), len(bOnly))
+	}
+
+	a, b := build(1, 2, 3), build(1, 5)
+	common, aOnly, bOnly = DiffChains(a, b)
+	if common != 1 || len(aOnly) != 2 || len(bOnly) != 1 {
+		t.Fatalf("fork: got common %d, %d/%d unique blocks", common, len(aOnly), len(bOnly))
+	}
+	if aOnly[0] != a.Blocks[2] || bOnly[0] != b.Blocks[2] {
+		t.Error("fork: unique blocks do not start after the common height")
+	}
+
+	common, _, _ = DiffChains(NewBlockchain(testEpoch), NewBlockchain(testEpoch.Add(time.Hour)))
+	if common != -1 {
+		t.Errorf("different genesis: got common %d, want -1", common)
+	}
+}
+
+// fakeClock is a Clock that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestFakeClockTimestampsBlocks(t *testing.T) {
+	clock := &fakeClock{now: testEpoch}
+	vm := NewVirtualMachineWithClock(clock)
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := vm.CreateAccount(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(10 * time.Second)
+	mustSend(t, vm, "alice", "bob", 1)
+	clock.Advance(time.Minute)
+	mustSend(t, vm, "bob", "alice", 1)
+
+	blocks := vm.Blockchain.Blocks
+	if !blocks[0].Timestamp.Equal(testEpoch) {
+		t.Errorf("genesis timestamp = %v, want %v", blocks[0].Timestamp, testEpoch)
+	}
+	if want := testEpoch.Add(70 * time.Second); !blocks[2].Timestamp.Equal(want) {
+		t.Errorf("block 2 timestamp = %v, want %v", blocks[2].Timestamp, want)
+	}
+	start, end, gap := vm.Blockchain.LongestGap()
+	if start != 1 || end != 2 || gap != time.Minute {
+		t.Errorf("LongestGap() = %d, %d, %v; want 1, 2, 1m0s", start, end, gap)
+	}
+}
+
+func TestDisplayNameKeepsIdentityAndHashes(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	tx := mustSend(t, vm, "alice", "bob", 5)
+	txID, blockHash := tx.ID, vm.Blockchain.Blocks[1].Hash
+
+	if err := vm.SetDisplayName("alice", "Alice Smith"); err != nil {
+		t.Fatal(err)
+	}
+	if account := vm.GetAccount("alice"); account == nil || account.DisplayName != "Alice Smith" {
+		t.Fatalf("GetAccount(alice) = %+v", account)
+	}
+	if got := tx.hashTransaction(); got != txID {
+		t.Errorf("transaction hash changed to %s", got)
+	}
+	if got := vm.Blockchain.Blocks[1].hashBlock(); got != blockHash {
+		t.Errorf("block hash changed to %s", got)
+	}
+	if err := vm.Blockchain.ValidateChain(); err != nil {
+		t.Errorf("ValidateChain: %v", err)
+	}
+	if err := vm.SetDisplayName("carol", "Carol"); err == nil {
+		t.Error("SetDisplayName on a missing account succeeded")
+	}
+}
+
+func TestFindTransactions(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	for _, amount := range []float64{1, 5, 10, 50, 100} {
+		mustSend(t, vm, "alice", "bob", amount)
+	}
+	tx, err := NewDataTransaction([]byte("memo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.AddBlockToChain([]*Transaction{tx}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []float64
+	for _, tx := range vm.FindTransactions(5, 50) {
+		got = append(got, tx.Amount)
+	}
+	if len(got) != 3 || got[0] != 5 || got[1] != 10 || got[2] != 50 {
+		t.Errorf("FindTransactions(5, 50) amounts = %v, want [5 10 50]", got)
+	}
+	if got := vm.FindTransactions(0, 0); len(got) != 0 {
+		t.Errorf("FindTransactions(0, 0) returned %d transactions, want none (data transactions excluded)", len(got))
+	}
+}
+
+// withDifficulty sets block's difficulty and re-hashes it, as a miner claiming that difficulty would
+func withDifficulty(block *Block, difficulty int) {
+	block.Difficulty = difficulty
+	block.Hash = block.hashBlock()
+}
+
+func TestValidateChainRejectsUnmetDifficulty(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	mustSend(t, vm, "alice", "bob", 1)
+	if err := vm.Blockchain.ValidateChain(); err != nil {
+		t.Fatalf("valid chain rejected: %v", err)
+	}
+
+	// No SHA-256 hash has 64 leading zero digits in practice
+	withDifficulty(vm.Blockchain.Blocks[1], 64)
+	err := vm.Blockchain.ValidateChain()
+	if err == nil || !strings.Contains(err.Error(), "difficulty") {
+		t.Errorf("ValidateChain() = %v, want a difficulty error", err)
+	}
+}
+
+func TestChainViewMatchesChain(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	vm.SetDisplayName("bob", "Bob")
+	mustSend(t, vm, "alice", "bob", 2.5)
+	data, err := NewDataTransaction([]byte{0xab, 0xcd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.AddBlockToChain([]*Transaction{data}); err != nil {
+		t.Fatal(err)
+	}
+
+	views := vm.ChainView()
+	if len(views) != len(vm.Blockchain.Blocks) {
+		t.Fatalf("got %d block views for %d blocks", len(views), len(vm.Blockchain.Blocks))
+	}
+	for i, view := range views {
+		block := vm.Blockchain.Blocks[i]
+		if view.Height != i || view.Hash != block.Hash || view.PrevHash != block.PrevBlockHash || !view.Timestamp.Equal(block.Timestamp) {
+			t.Errorf("block %d view %+v does not match the block", i, view)
+		}
+		if len(view.Transactions) != len(block.Transactions) {
+			t.Errorf("block %d view has %d transactions, want %d", i, len(view.Transactions), len(block.Transactions))
+		}
+	}
+	transfer := views[1].Transactions[0]
+	if transfer.From != "alice" || transfer.To != "bob" || transfer.ToName != "Bob" || transfer.Amount != 2.5 {
+		t.Errorf("transfer view = %+v", transfer)
+	}
+	if anchor := views[2].Transactions[0]; anchor.Data != "abcd" || anchor.From != "" {
+		t.Errorf("data transaction view = %+v", anchor)
+	}
+
+	// The JSON form must carry the same hashes
+	encoded, err := json.Marshal(views)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []BlockView
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded[1].Transactions[0].ID != vm.Blockchain.Blocks[1].Transactions[0].ID {
+		t.Error("JSON round trip lost the transaction ID")
+	}
+}
+
+func TestInactiveAccounts(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob", "carol", "dave")
+	mustSend(t, vm, "alice", "bob", 1)
+
+	got := vm.InactiveAccounts()
+	if len(got) != 2 || got[0] != "carol" || got[1] != "dave" {
+		t.Fatalf("InactiveAccounts() = %v, want [carol dave]", got)
+	}
+	pruned, err := vm.PruneInactiveAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 2 || vm.GetAccount("carol") != nil || vm.GetAccount("alice") == nil {
+		t.Errorf("PruneInactiveAccounts() = %v, accounts left: %d", pruned, len(vm.Accounts))
+	}
+}
+
+func TestDumpBlockPreimageHashesToStoredHash(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	mustSend(t, vm, "alice", "bob", 3)
+
+	var out bytes.Buffer
+	if err := dumpBlock(vm, &out, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, preimage, found := strings.Cut(out.String(), "Hash preimage: ")
+	if !found {
+		t.Fatalf("dump has no hash preimage:\n%s", out.String())
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSuffix(preimage, "\n")))
+	if got := hex.EncodeToString(sum[:]); got != vm.Blockchain.Blocks[1].Hash {
+		t.Errorf("re-hashed preimage = %s, want stored hash %s", got, vm.Blockchain.Blocks[1].Hash)
+	}
+	if err := dumpBlock(vm, &out, 5); err == nil {
+		t.Error("dumping an out-of-range height succeeded")
+	}
+}
+
+func TestExecuteSwapIsAllOrNothing(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	alice, bob := vm.GetAccount("alice"), vm.GetAccount("bob")
+
+	if err := vm.ExecuteSwap(NewAtomicSwap(alice, bob, 10, 20)); err != nil {
+		t.Fatalf("valid swap rejected: %v", err)
+	}
+	if len(vm.Blockchain.Blocks) != 2 || len(vm.Blockchain.Blocks[1].Transactions) != 2 {
+		t.Fatal("a valid swap should commit both legs in a single block")
+	}
+
+	rejected := []*AtomicSwap{
+		NewAtomicSwap(alice, bob, 10, -1),
+		NewAtomicSwap(alice, alice, 10, 10),
+		{First: NewTransaction(alice, bob, 1), Second: NewTransaction(alice, bob, 1)},
+	}
+	for _, swap := range rejected {
+		if err := vm.ExecuteSwap(swap); err == nil {
+			t.Errorf("swap %+v was accepted", swap)
+		}
+	}
+	if len(vm.Blockchain.Blocks) != 2 {
+		t.Errorf("rejected swaps added %d blocks", len(vm.Blockchain.Blocks)-2)
+	}
+}
+
+func TestRejectedSendGoesToDeadLetter(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	var sink bytes.Buffer
+	vm.DeadLetter = &sink
+
+	if _, err := runCommand(vm, "send alice alice 5"); err == nil {
+		t.Fatal("self-transfer was not rejected")
+	}
+	var record RejectedTransaction
+	if err := json.Unmarshal(sink.Bytes(), &record); err != nil {
+		t.Fatalf("dead-letter record %q: %v", sink.String(), err)
+	}
+	if record.Sender != "alice" || record.Receiver != "alice" || record.Amount != "5" || !strings.Contains(record.Reason, "self-transfer") {
+		t.Errorf("dead-letter record = %+v", record)
+	}
+}
+
+func TestRejectedSwapGoesToDeadLetter(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	var sink bytes.Buffer
+	vm.DeadLetter = &sink
+
+	for _, command := range []string{"swap alice bob 1.234 1", "swap alice bob 1 1.234", "swap alice nobody 1 1"} {
+		sink.Reset()
+		if _, err := runCommand(vm, command); err == nil {
+			t.Fatalf("%q was not rejected", command)
+		}
+		lines := strings.Split(strings.TrimSpace(sink.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("%q wrote %d dead-letter records, want one per leg", command, len(lines))
+		}
+		var first, second RejectedTransaction
+		if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+			t.Fatal(err)
+		}
+		if first.Sender != "alice" || second.Sender != first.Receiver || second.Receiver != "alice" || !strings.HasPrefix(first.Reason, "swap rejected") {
+			t.Errorf("%q recorded %+v and %+v", command, first, second)
+		}
+	}
+}
+
+func TestBenchmarkGrowsChain(t *testing.T) {
+	vm := newTestVM(t)
+	if _, err := vm.Benchmark(3, 5); err != nil {
+		t.Fatal(err)
+	}
+	if len(vm.Accounts) != 3 {
+		t.Errorf("got %d accounts, want 3", len(vm.Accounts))
+	}
+	if len(vm.Blockchain.Blocks) != 6 {
+		t.Errorf("got %d blocks, want genesis plus 5", len(vm.Blockchain.Blocks))
+	}
+	// A second run reuses the existing accounts
+	if _, err := vm.Benchmark(3, 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(vm.Accounts) != 3 || len(vm.Blockchain.Blocks) != 8 {
+		t.Errorf("after second run: %d accounts, %d blocks", len(vm.Accounts), len(vm.Blockchain.Blocks))
+	}
+	if err := vm.Blockchain.ValidateChain(); err != nil {
+		t.Error(err)
+	}
+
+	vm.UsernamePolicy.MaxLength = 6
+	if _, err := vm.Benchmark(12, 1); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("Benchmark with a name the policy rejects = %v, want ErrInvalidUsername", err)
+	}
+}
+
+func TestWatchTransactionFiresOnce(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	tx := mustSend(t, vm, "alice", "bob", 1)
+
+	fired := 0
+	vm.WatchTransaction(tx.ID, 3, func(got *Transaction) {
+		if got != tx {
+			t.Errorf("callback got transaction %s, want %s", got.ID, tx.ID)
+		}
+		fired++
+	})
+	mustSend(t, vm, "bob", "alice", 1)
+	if fired != 0 {
+		t.Fatal("callback fired after 2 confirmations")
+	}
+	for i := 0; i < 3; i++ {
+		mustSend(t, vm, "alice", "bob", 1)
+	}
+	if fired != 1 {
+		t.Errorf("callback fired %d times, want exactly once", fired)
+	}
+}
+
+func TestBlocksInRange(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	for i := 0; i < 4; i++ {
+		mustSend(t, vm, "alice", "bob", 1)
+	}
+
+	blocks, err := vm.Blockchain.BlocksInRange(1, 3)
+	if err != nil || len(blocks) != 3 || blocks[0] != vm.Blockchain.Blocks[1] {
+		t.Errorf("BlocksInRange(1, 3) = %d blocks, %v", len(blocks), err)
+	}
+	if blocks, err := vm.Blockchain.BlocksInRange(4, 4); err != nil || len(blocks) != 1 {
+		t.Errorf("BlocksInRange(4, 4) = %d blocks, %v", len(blocks), err)
+	}
+	for _, r := range [][2]int{{3, 1}, {-1, 2}, {0, 5}} {
+		if _, err := vm.Blockchain.BlocksInRange(r[0], r[1]); err == nil {
+			t.Errorf("BlocksInRange(%d, %d) succeeded", r[0], r[1])
+		}
+	}
+
+	// The result is a copy, so changing it leaves the chain alone
+	blocks[0] = nil
+	if vm.Blockchain.Blocks[1] == nil {
+		t.Error("BlocksInRange returned the chain's own slice")
+	}
+}
+
+func TestMigrateChain(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	mustSend(t, vm, "alice", "bob", 1)
+	mustSend(t, vm, "bob", "alice", 2)
+	sha512Config := HashConfig{Name: "sha512", New: sha512.New}
+
+	migrated, err := vm.Blockchain.MigrateChain(SHA256Config, sha512Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrated.validateChainWith(sha512Config); err != nil {
+		t.Errorf("migrated chain does not validate under sha512: %v", err)
+	}
+	if len(migrated.Blocks) != 3 || migrated.Blocks[1].Hash == vm.Blockchain.Blocks[1].Hash {
+		t.Error("blocks were not re-hashed")
+	}
+	if migrated.Blocks[2].Transactions[0] != vm.Blockchain.Blocks[2].Transactions[0] {
+		t.Error("transactions were not kept")
+	}
+	if _, err := migrated.MigrateChain(SHA256Config, sha512Config); err == nil {
+		t.Error("migrating from the wrong hash configuration succeeded")
+	}
+}
+
+func TestParseAmountPrecision(t *testing.T) {
+	vm := newTestVM(t)
+	valid := map[string]float64{"12.34": 12.34, "12.3": 12.3, "12.340": 12.34, "7": 7, "0": 0}
+	for input, want := range valid {
+		if got, err := vm.ParseAmount(input); err != nil || got != want {
+			t.Errorf("ParseAmount(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"12.345", "0.001", "abc", "NaN", "1e3", "0x10", ""} {
+		if got, err := vm.ParseAmount(input); err == nil {
+			t.Errorf("ParseAmount(%q) = %v, want an error", input, got)
+		}
+	}
+	vm.DisplayDecimals = 0
+	if _, err := vm.ParseAmount("1.5"); err == nil {
+		t.Error("ParseAmount(1.5) with 0 decimals succeeded")
+	}
+}
+
+func TestDataTransactionMinedAndRetrievable(t *testing.T) {
+	vm := newTestVM(t)
+	tx, err := NewDataTransaction([]byte("document digest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.AddBlockToChain([]*Transaction{tx}); err != nil {
+		t.Fatal(err)
+	}
+	found, height := vm.findTransaction(tx.ID)
+	if found != tx || height != 1 || string(found.Data) != "document digest" {
+		t.Errorf("findTransaction(%s) = %v at height %d", tx.ID, found, height)
+	}
+	if err := vm.Blockchain.ValidateChain(); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewDataTransaction(make([]byte, MaxDataSize+1)); err == nil {
+		t.Error("oversized data transaction was accepted")
+	}
+
+	// A payload spelling out a transfer's hash record must not take its ID
+	vm = newTestVM(t, "alice", "bob")
+	transfer := NewTransaction(vm.GetAccount("alice"), vm.GetAccount("bob"), 1)
+	lookalike, err := NewDataTransaction([]byte("alicebob1.000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookalike.ID == transfer.ID {
+		t.Error("data transaction reproduced a transfer ID")
+	}
+}
+
+func TestTipInfoFollowsLatestBlock(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	if tip := vm.TipInfo(); tip.Height != 0 || tip.Hash != vm.Blockchain.Blocks[0].Hash {
+		t.Errorf("genesis tip = %+v", tip)
+	}
+	mustSend(t, vm, "alice", "bob", 1)
+	mustSend(t, vm, "alice", "bob", 2)
+	latest := vm.Blockchain.Blocks[2]
+	if tip := vm.TipInfo(); tip.Height != 2 || tip.Hash != latest.Hash || !tip.Timestamp.Equal(latest.Timestamp) {
+		t.Errorf("tip = %+v, want height 2 hash %s", tip, latest.Hash)
+	}
+}
+
+func TestGenesisMessage(t *testing.T) {
+	a := NewBlockchainWithMessage(testEpoch, "hello")
+	b := NewBlockchainWithMessage(testEpoch, "world")
+	if a.Blocks[0].Hash == b.Blocks[0].Hash {
+		t.Error("different genesis messages produced the same hash")
+	}
+	if a.Blocks[0].Message != "hello" {
+		t.Errorf("genesis message = %q", a.Blocks[0].Message)
+	}
+	vm := NewVirtualMachineWithOptions(VMOptions{GenesisMessage: "launch"})
+	if got := vm.ChainView()[0].Message; got != "launch" {
+		t.Errorf("genesis message in view = %q", got)
+	}
+
+	// The message must not run into the difficulty in the hashed record
+	x := &Block{Timestamp: testEpoch, Difficulty: 1, Message: "2"}
+	y := &Block{Timestamp: testEpoch, Difficulty: 12}
+	if x.hashBlock() == y.hashBlock() {
+		t.Error("difficulty 1 with message \"2\" hashes like difficulty 12")
+	}
+}
+
+func TestEstimateTxCost(t *testing.T) {
+	alice, bob := NewAccount("alice"), NewAccount("bob")
+	bare := EstimateTxCost(NewTransaction(alice, bob, 1))
+	if want := TxBaseCost + len("alicebob")*TxByteCost; bare != want {
+		t.Errorf("bare transfer cost = %d, want %d", bare, want)
+	}
+	small, _ := NewDataTransaction([]byte("hi"))
+	large, _ := NewDataTransaction(make([]byte, MaxDataSize))
+	if EstimateTxCost(large) <= EstimateTxCost(small) {
+		t.Error("a large payload should cost more than a small one")
+	}
+	if got := EstimateTxCost(NewCategorizedTransaction(alice, bob, 1, "salary")); got != bare+len("salary")*TxByteCost {
+		t.Errorf("categorized transfer cost = %d, want %d", got, bare+len("salary")*TxByteCost)
+	}
+	// Costs depend only on size, so the amount makes no difference
+	if EstimateTxCost(NewTransaction(alice, bob, 1000000)) != bare {
+		t.Error("cost depends on the amount")
+	}
+}
+
+func TestRunLoopEndsAtEndOfInput(t *testing.T) {
+	vm := newTestVM(t)
+	// No trailing "exit" and no final newline
+	if err := runLoop(vm, strings.NewReader("create_account alice\ncreate_account bob"), false); err != nil {
+		t.Fatalf("runLoop() = %v", err)
+	}
+	if vm.GetAccount("alice") == nil || vm.GetAccount("bob") == nil {
+		t.Error("commands before end of input were not all run")
+	}
+	if err := runLoop(vm, strings.NewReader(""), false); err != nil {
+		t.Errorf("runLoop on empty input = %v", err)
+	}
+}
+
+func TestChangesSince(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob", "carol")
+	mustSend(t, vm, "alice", "bob", 10)
+	mustSend(t, vm, "bob", "carol", 4)
+	second := mustSend(t, vm, "carol", "bob", 1)
+	mustSend(t, vm, "alice", "carol", 7)
+
+	changes := vm.ChangesSince("bob", 2)
+	if len(changes) != 1 || changes[0].Height != 3 || changes[0].TxID != second.ID || changes[0].Delta != 1 {
+		t.Errorf("ChangesSince(bob, 2) = %+v", changes)
+	}
+	var total float64
+	for _, change := range vm.ChangesSince("bob", -1) {
+		total += change.Delta
+	}
+	if total != 7 {
+		t.Errorf("bob's total delta since genesis = %v, want 7", total)
+	}
+	if got := vm.ChangesSince("bob", 4); len(got) != 0 {
+		t.Errorf("ChangesSince at the tip = %+v, want none", got)
+	}
+}
+
+func TestUsernamePolicy(t *testing.T) {
+	policy := UsernamePolicy{MinLength: 3, MaxLength: 8, AllowedChars: "abcdefghijklmnopqrstuvwxyz0123456789_"}
+	cases := map[string]bool{
+		"ab":        false,
+		"abcdefghi": false,
+		"bad-name":  false,
+		"has space": false,
+		"alice_1":   true,
+		"bob":       true,
+	}
+	for username, ok := range cases {
+		err := policy.Validate(username)
+		if ok && err != nil {
+			t.Errorf("Validate(%q) = %v, want nil", username, err)
+		}
+		if !ok && !errors.Is(err, ErrInvalidUsername) {
+			t.Errorf("Validate(%q) = %v, want ErrInvalidUsername", username, err)
+		}
+	}
+
+	vm := newTestVM(t)
+	vm.UsernamePolicy = policy
+	if _, err := vm.CreateAccount("ab"); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("CreateAccount(ab) = %v, want ErrInvalidUsername", err)
+	}
+	if len(vm.Accounts) != 0 {
+		t.Error("an invalid username was registered")
+	}
+
+	vm.UsernamePolicy = DefaultUsernamePolicy
+	vm.UsernamePolicy.CaseSensitive = false
+	if _, err := vm.CreateAccount("Alice"); err != nil {
+		t.Fatal(err)
+	}
+	if vm.GetAccount("ALICE") == nil {
+		t.Error("case-insensitive lookup failed")
+	}
+	if _, err := vm.CreateAccount("alice"); !errors.Is(err, ErrAccountExists) {
+		t.Errorf("CreateAccount(alice) after Alice = %v, want ErrAccountExists", err)
+	}
+}
+
+func TestCompactChain(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	var sent []*Transaction
+	for i := 1; i <= 10; i++ {
+		sent = append(sent, mustSend(t, vm, "alice", "bob", float64(i)))
+	}
+
+	compacted := vm.Blockchain.CompactChain(4)
+	if len(compacted.Blocks) != 4 {
+		t.Fatalf("compacted into %d blocks, want genesis plus 3", len(compacted.Blocks))
+	}
+	var kept []*Transaction
+	for _, block := range compacted.Blocks[1:] {
+		if len(block.Transactions) > 4 {
+			t.Errorf("block has %d transactions, limit is 4", len(block.Transactions))
+		}
+		kept = append(kept, block.Transactions...)
+	}
+	if len(kept) != len(sent) {
+		t.Fatalf("kept %d transactions, want %d", len(kept), len(sent))
+	}
+	for i := range sent {
+		if kept[i] != sent[i] {
+			t.Errorf("transaction %d out of order after compaction", i)
+		}
+	}
+	if compacted.Blocks[0] != vm.Blockchain.Blocks[0] {
+		t.Error("genesis block was not kept")
+	}
+	if err := compacted.ValidateChain(); err != nil {
+		t.Error(err)
+	}
+	if len(vm.Blockchain.Blocks) != 11 {
+		t.Error("CompactChain modified the source chain")
+	}
+}
+
+// failedChecks returns the names of the validation checks tx fails on vm
+func failedChecks(vm *VirtualMachine, tx *Transaction) []string {
+	var failed []string
+	for _, check := range vm.ExplainValidation(tx) {
+		if !check.Passed {
+			failed = append(failed, check.Name)
+		}
+	}
+	return failed
+}
+
+func TestExplainValidation(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	alice, bob := vm.GetAccount("alice"), vm.GetAccount("bob")
+	cases := []struct {
+		name string
+		tx   *Transaction
+		want string
+	}{
+		{"unregistered sender", NewTransaction(NewAccount("mallory"), bob, 1), "sender registered"},
+		{"unregistered receiver", NewTransaction(alice, NewAccount("mallory"), 1), "receiver registered"},
+		{"zero amount", NewTransaction(alice, bob, 0), "positive amount"},
+		{"negative amount", NewTransaction(alice, bob, -5), "positive amount"},
+		{"self-transfer", NewTransaction(alice, alice, 1), "not self-transfer"},
+	}
+	for _, c := range cases {
+		failed := failedChecks(vm, c.tx)
+		if len(failed) != 1 || failed[0] != c.want {
+			t.Errorf("%s: failed checks %v, want [%s]", c.name, failed, c.want)
+		}
+		if err := vm.ValidateTransaction(c.tx); err == nil || !strings.HasPrefix(err.Error(), c.want) {
+			t.Errorf("%s: ValidateTransaction() = %v", c.name, err)
+		}
+	}
+	if failed := failedChecks(vm, NewTransaction(alice, bob, 1)); len(failed) != 0 {
+		t.Errorf("valid transfer failed %v", failed)
+	}
+
+	// Swaps go through the same checks
+	vm.MinAmount = 10
+	if err := vm.ExecuteSwap(NewAtomicSwap(alice, bob, 1, 1)); err == nil {
+		t.Error("a swap below the minimum amount was committed")
+	}
+}
+
+func TestDeterministicMode(t *testing.T) {
+	build := func() *VirtualMachine {
+		vm := newTestVM(t, "alice", "bob")
+		mustSend(t, vm, "alice", "bob", 1)
+		mustSend(t, vm, "bob", "alice", 2)
+		return vm
+	}
+	a, b := build(), build()
+	for height, block := range a.Blockchain.Blocks {
+		if want := time.Unix(int64(height), 0).UTC(); !block.Timestamp.Equal(want) {
+			t.Errorf("block %d timestamp = %v, want %v", height, block.Timestamp, want)
+		}
+	}
+	if a.Blockchain.ChainDigest() != b.Blockchain.ChainDigest() {
+		t.Error("two deterministic runs produced different chains")
+	}
+}
+
+func TestAverageAmount(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	for _, amount := range []float64{2, 4, 9} {
+		mustSend(t, vm, "alice", "bob", amount)
+	}
+	if avg, err := vm.AverageAmount(1, 3); err != nil || avg != 5 {
+		t.Errorf("AverageAmount(1, 3) = %v, %v; want 5", avg, err)
+	}
+	if avg, err := vm.AverageAmount(2, 3); err != nil || avg != 6.5 {
+		t.Errorf("AverageAmount(2, 3) = %v, %v; want 6.5", avg, err)
+	}
+	if avg, err := vm.AverageAmount(0, 0); err != nil || avg != 0 {
+		t.Errorf("AverageAmount over genesis only = %v, %v; want 0", avg, err)
+	}
+	if _, err := vm.AverageAmount(3, 1); err == nil {
+		t.Error("inverted range succeeded")
+	}
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	vm.SetDisplayName("alice", "Alice")
+	mustSend(t, vm, "alice", "bob", 1.25)
+	data, _ := NewDataTransaction([]byte{1, 2, 3})
+	if err := vm.AddBlockToChain([]*Transaction{data}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"json", "gob"} {
+		codec, err := CodecByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "chain."+name)
+		if err := vm.Blockchain.SaveToFile(path, codec); err != nil {
+			t.Fatalf("%s: save: %v", name, err)
+		}
+		loaded, err := LoadFromFile(path, codec)
+		if err != nil {
+			t.Fatalf("%s: load: %v", name, err)
+		}
+		if loaded.ChainDigest() != vm.Blockchain.ChainDigest() {
+			t.Errorf("%s: block hashes changed in the round trip", name)
+		}
+		if err := loaded.ValidateChain(); err != nil {
+			t.Errorf("%s: loaded chain is invalid: %v", name, err)
+		}
+		if got := loaded.Blocks[1].Transactions[0].Sender.DisplayName; got != "Alice" {
+			t.Errorf("%s: display name = %q after the round trip", name, got)
+		}
+	}
+	if _, err := CodecByName("xml"); err == nil {
+		t.Error("CodecByName(xml) succeeded")
+	}
+}
+
+func TestExportDOT(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	mustSend(t, vm, "alice", "bob", 1)
+	mustSend(t, vm, "bob", "alice", 1)
+
+	var out bytes.Buffer
+	if err := vm.Blockchain.ExportDOT(&out); err != nil {
+		t.Fatal(err)
+	}
+	dot := out.String()
+	if nodes := strings.Count(dot, "[label="); nodes != 3 {
+		t.Errorf("got %d nodes, want 3", nodes)
+	}
+	if edges := strings.Count(dot, " -> "); edges != 2 {
+		t.Errorf("got %d edges, want 2", edges)
+	}
+	if !strings.HasPrefix(dot, "digraph blockchain {") || !strings.HasSuffix(dot, "}\n") {
+		t.Errorf("output is not a DOT digraph:\n%s", dot)
+	}
+}
+
+func TestLongestGap(t *testing.T) {
+	bc := NewBlockchain(testEpoch)
+	for _, offset := range []time.Duration{time.Second, 3 * time.Second, 10 * time.Second, 11 * time.Second} {
+		bc.AddBlock([]*Transaction{}, testEpoch.Add(offset))
+	}
+	start, end, gap := bc.LongestGap()
+	if start != 2 || end != 3 || gap != 7*time.Second {
+		t.Errorf("LongestGap() = %d, %d, %v; want 2, 3, 7s", start, end, gap)
+	}
+	if start, end, gap := NewBlockchain(testEpoch).LongestGap(); start != 0 || end != 0 || gap != 0 {
+		t.Errorf("genesis-only LongestGap() = %d, %d, %v", start, end, gap)
+	}
+}
+
+func TestReadOnlyVM(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob", "carol")
+	mustSend(t, vm, "alice", "bob", 5)
+	spend := mustSend(t, vm, "bob", "carol", 3)
+	vm.MinAmount = 2
+	ro := NewReadOnlyVM(vm)
+
+	if ro.GetAccount("alice") == nil || len(ro.ChainView()) != 3 {
+		t.Error("reads on the observer do not see the source's state")
+	}
+	want, err := vm.Provenance(spend.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ro.Provenance(spend.ID); err != nil || len(got) != len(want) || len(got) == 0 {
+		t.Errorf("observer Provenance = %d hops, %v; source has %d", len(got), err, len(want))
+	}
+	small := NewTransaction(vm.GetAccount("alice"), vm.GetAccount("bob"), 1)
+	if (ro.ValidateTransaction(small) == nil) != (vm.ValidateTransaction(small) == nil) {
+		t.Error("observer and source disagree on validation")
+	}
+	if ro.ShortHash(spend.ID) != vm.ShortHash(spend.ID) {
+		t.Error("observer shortens hashes differently")
+	}
+
+	alice, bob := vm.GetAccount("alice"), vm.GetAccount("bob")
+	writes := map[string]error{}
+	_, writes["CreateAccount"] = ro.CreateAccount("dave")
+	writes["AddBlockToChain"] = ro.AddBlockToChain([]*Transaction{NewTransaction(alice, bob, 5)})
+	writes["ExecuteSwap"] = ro.ExecuteSwap(NewAtomicSwap(alice, bob, 5, 5))
+	writes["SetAlias"] = ro.SetAlias("al", "alice")
+	writes["SetDisplayName"] = ro.SetDisplayName("alice", "Alice")
+	_, writes["PruneInactiveAccounts"] = ro.PruneInactiveAccounts()
+	_, writes["Benchmark"] = ro.Benchmark(2, 1)
+	writes["LoadChain"] = ro.LoadChain(NewBlockchain(testEpoch))
+	for name, err := range writes {
+		if !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s on the observer = %v, want ErrReadOnly", name, err)
+		}
+	}
+	if len(vm.Blockchain.Blocks) != 3 || len(vm.Accounts) != 3 || vm.GetAccount("alice").DisplayName != "" {
+		t.Error("a write through the observer changed the source")
+	}
+}
+
+func TestVerifyChainFile(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	mustSend(t, vm, "alice", "bob", 4)
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.json")
+	if err := vm.Blockchain.SaveToFile(good, JSONCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyChainFile(good); !ok {
+		t.Errorf("good file reported invalid: %v", err)
+	}
+
+	data, err := os.ReadFile(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := map[string]string{
+		"tampered.json":  strings.Replace(string(data), `"Amount": 4`, `"Amount": 40`, 1),
+		"nil-tx.json":    `{"Blocks":[{"Transactions":[null]}]}`,
+		"nil-block.json": `{"Blocks":[null]}`,
+		"empty.json":     `{"Blocks":[]}`,
+		"garbage.json":   `not json`,
+	}
+	for name, content := range corrupted {
+		if content == string(data) {
+			t.Fatalf("%s: corruption did not change the file", name)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := VerifyChainFile(path); ok || err == nil {
+			t.Errorf("%s: reported valid", name)
+		}
+	}
+	if ok, _ := VerifyChainFile(filepath.Join(dir, "missing.json")); ok {
+		t.Error("missing file reported valid")
+	}
+}
+
+func TestTrustImported(t *testing.T) {
+	// underDifficulty builds a fresh chain whose blocks claim a difficulty they do not meet
+	underDifficulty := func() *Blockchain {
+		source := newTestVM(t, "alice", "bob")
+		mustSend(t, source, "alice", "bob", 1)
+		mustSend(t, source, "bob", "alice", 1)
+		bc := source.Blockchain
+		for i, block := range bc.Blocks[1:] {
+			block.PrevBlockHash = bc.Blocks[i].Hash
+			withDifficulty(block, 64)
+		}
+		return bc
+	}
+
+	vm := newTestVM(t)
+	if err := vm.LoadChain(underDifficulty()); err == nil {
+		t.Fatal("under-difficulty chain was accepted without trust")
+	}
+	vm.TrustImported = true
+	if err := vm.LoadChain(underDifficulty()); err != nil {
+		t.Fatalf("under-difficulty chain was rejected with trust: %v", err)
+	}
+	if len(vm.Blockchain.Blocks) != 3 || vm.GetAccount("alice") == nil {
+		t.Error("trusted chain was not loaded")
+	}
+
+	// Trust skips only the proof-of-work check; broken links still fail
+	broken := underDifficulty()
+	broken.Blocks[2].PrevBlockHash = "bogus"
+	broken.Blocks[2].Hash = broken.Blocks[2].hashBlock()
+	if err := vm.LoadChain(broken); err == nil {
+		t.Error("trusted chain with a broken link was accepted")
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob", "carol", "dave", "xavier")
+	fromAlice := mustSend(t, vm, "alice", "bob", 5)
+	fromXavier := mustSend(t, vm, "xavier", "bob", 2)
+	toCarol := mustSend(t, vm, "bob", "carol", 6)
+	toDave := mustSend(t, vm, "carol", "dave", 1)
+
+	path, err := vm.Provenance(toDave.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Transaction{toCarol, fromXavier, fromAlice}
+	if len(path) != len(want) {
+		t.Fatalf("path has %d transfers, want %d", len(path), len(want))
+	}
+	for i := range want {
+		if path[i] != want[i] {
+			t.Errorf("path[%d] = %s -> %s, want %s -> %s", i,
+				path[i].Sender.Username, path[i].Receiver.Username, want[i].Sender.Username, want[i].Receiver.Username)
+		}
+	}
+
+	vm.ProvenanceDepth = 1
+	if path, _ := vm.Provenance(toDave.ID); len(path) != 1 || path[0] != toCarol {
+		t.Errorf("depth-1 path = %d transfers, want only bob -> carol", len(path))
+	}
+	if _, err := vm.Provenance("missing"); err == nil {
+		t.Error("Provenance of a missing transaction succeeded")
+	}
+}
+
+func TestCreateAccounts(t *testing.T) {
+	vm := newTestVM(t, "user2")
+	created, err := vm.CreateAccounts("user", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 4 || len(vm.Accounts) != 5 {
+		t.Errorf("created %d accounts, %d in total; want 4 new and 5 in total", len(created), len(vm.Accounts))
+	}
+	for i := 0; i < 5; i++ {
+		if vm.GetAccount(fmt.Sprintf("user%d", i)) == nil {
+			t.Errorf("user%d is missing", i)
+		}
+	}
+
+	vm.UsernamePolicy.MaxLength = 5
+	created, err = vm.CreateAccounts("new", 200)
+	if !errors.Is(err, ErrInvalidUsername) || len(created) != 100 {
+		t.Errorf("CreateAccounts past the policy limit = %d created, %v", len(created), err)
+	}
+}
+
+func TestChainDigest(t *testing.T) {
+	build := func(amounts ...float64) *VirtualMachine {
+		vm := newTestVM(t, "alice", "bob")
+		for _, amount := range amounts {
+			mustSend(t, vm, "alice", "bob", amount)
+		}
+		return vm
+	}
+	a, b := build(1, 2), build(1, 2)
+	if a.Blockchain.ChainDigest() != b.Blockchain.ChainDigest() {
+		t.Error("equal chains have different digests")
+	}
+	if a.Blockchain.ChainDigest() == build(1, 3).Blockchain.ChainDigest() {
+		t.Error("chains differing in one block have the same digest")
+	}
+	if a.Blockchain.ChainDigest() == build(1).Blockchain.ChainDigest() {
+		t.Error("a chain and its prefix have the same digest")
+	}
+}
+
+func TestSplitCommands(t *testing.T) {
+	cases := map[string][]string{
+		"create_account a; create_account b": {"create_account a", "create_account b"},
+		"# note; create_account x":           nil,
+		"   # indented comment":              nil,
+		"tip;; ;tip":                         {"tip", "tip"},
+		"create_account a; # skipped":        {"create_account a"},
+	}
+	for line, want := range cases {
+		got := splitCommands(line)
+		if len(got) != len(want) {
+			t.Errorf("splitCommands(%q) = %q, want %q", line, got, want)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("splitCommands(%q) = %q, want %q", line, got, want)
+				break
+			}
+		}
+	}
+
+	vm := newTestVM(t)
+	input := "create_account a; create_account b\n# create_account c; create_account d\nexit\n"
+	if err := runLoop(vm, strings.NewReader(input), true); err != nil {
+		t.Fatal(err)
+	}
+	if len(vm.Accounts) != 2 || vm.GetAccount("d") != nil {
+		t.Errorf("got %d accounts, want only a and b", len(vm.Accounts))
+	}
+}
+
+func TestShortHashResolution(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	var txs []*Transaction
+	for i := 1; i <= 20; i++ {
+		txs = append(txs, mustSend(t, vm, "alice", "bob", float64(i)))
+	}
+
+	target := vm.Blockchain.Blocks[5]
+	block, height, err := vm.BlockByHash(vm.ShortHash(target.Hash))
+	if err != nil || block != target || height != 5 {
+		t.Errorf("BlockByHash(short hash) = %d, %v; want height 5", height, err)
+	}
+	if id, err := vm.ResolveTransactionID(vm.ShortHash(txs[3].ID)); err != nil || id != txs[3].ID {
+		t.Errorf("ResolveTransactionID(short ID) = %s, %v", id, err)
+	}
+
+	// 21 hashes over 16 hex digits must share a first digit
+	byFirst := map[byte]int{}
+	for _, block := range vm.Blockchain.Blocks {
+		byFirst[block.Hash[0]]++
+	}
+	for first, count := range byFirst {
+		if count > 1 {
+			if _, _, err := vm.BlockByHash(string(first)); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+				t.Errorf("BlockByHash(%q) = %v, want an ambiguity error", first, err)
+			}
+			break
+		}
+	}
+	if _, _, err := vm.BlockByHash("zz"); err == nil {
+		t.Error("BlockByHash of an unknown prefix succeeded")
+	}
+
+	// watch accepts the short IDs shown in listings
+	if _, err := runCommand(vm, "watch "+vm.ShortHash(txs[0].ID)+" 1"); err != nil {
+		t.Errorf("watch with a short ID: %v", err)
+	}
+	if _, err := runCommand(vm, "watch 0123abc 1"); err == nil {
+		t.Error("watch of an unknown short ID succeeded")
+	}
+}
+
+func TestAliases(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	if err := vm.SetAlias("al", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if got := vm.lookupAccount("al"); got != vm.GetAccount("alice") {
+		t.Errorf("alias al resolved to %v", got)
+	}
+	if _, err := runCommand(vm, "send al bob 3"); err != nil {
+		t.Fatalf("send via alias: %v", err)
+	}
+	if tx := vm.Blockchain.Blocks[1].Transactions[0]; tx.Sender.Username != "alice" {
+		t.Errorf("send via alias used sender %s", tx.Sender.Username)
+	}
+	if _, err := runCommand(vm, "set_name al Alice"); err != nil || vm.GetAccount("alice").DisplayName != "Alice" {
+		t.Errorf("set_name via alias: %v", err)
+	}
+
+	if err := vm.SetAlias("bob", "alice"); err == nil {
+		t.Error("an alias shadowing a username was accepted")
+	}
+	if err := vm.SetAlias("ghost", "nobody"); err == nil {
+		t.Error("an alias to a missing account was accepted")
+	}
+	// A real account created later takes precedence over the alias
+	if _, err := vm.CreateAccount("al"); err != nil {
+		t.Fatal(err)
+	}
+	if got := vm.ResolveAlias("al"); got != "al" {
+		t.Errorf("ResolveAlias(al) = %s after creating account al", got)
+	}
+}
+
+func TestChangesResolvesAliasesAndCase(t *testing.T) {
+	vm := newTestVM(t)
+	vm.UsernamePolicy.CaseSensitive = false
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := vm.CreateAccount(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustSend(t, vm, "alice", "bob", 1)
+	if err := vm.SetAlias("al", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "Alice", "al"} {
+		out := captureStdout(t, func() {
+			if _, err := runCommand(vm, "changes "+name+" -1"); err != nil {
+				t.Errorf("changes %s: %v", name, err)
+			}
+		})
+		if strings.Count(out, "Delta:") != 1 {
+			t.Errorf("changes %s listed:\n%s", name, out)
+		}
+	}
+}
+
+// captureStdout returns everything f prints to standard output
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-done
+}
+
+func TestReplayChainFlagsTransfersBelowNewMinimum(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	mustSend(t, vm, "alice", "bob", 5)
+	small := mustSend(t, vm, "alice", "bob", 0.5)
+	data, err := NewDataTransaction([]byte("note"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.AddBlockToChain([]*Transaction{data}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := vm.ReplayChain(vm.Blockchain); len(got) != 0 {
+		t.Fatalf("replay against the original rules flagged %d transfers", len(got))
+	}
+	strict := newTestVM(t)
+	strict.MinAmount = 1
+	got := strict.ReplayChain(vm.Blockchain)
+	if len(got) != 1 {
+		t.Fatalf("replay flagged %d transfers, want 1", len(got))
+	}
+	if got[0].Tx != small || got[0].Height != 2 || !strings.HasPrefix(got[0].Reason, "minimum amount") {
+		t.Errorf("replay flagged %+v", got[0])
+	}
+	if len(vm.Blockchain.Blocks) != 4 || vm.MinAmount != 0 {
+		t.Error("replay modified the chain or the VM")
+	}
+}
+
+func TestOracleQuotes(t *testing.T) {
+	trusted := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	other := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+	vm := newTestVM(t, "alice", "bob")
+	vm.OraclePublicKey = trusted.Public().(ed25519.PublicKey)
+	alice, bob := vm.GetAccount("alice"), vm.GetAccount("bob")
+
+	valid := NewQuotedTransaction(alice, bob, 2, NewOracleQuote(trusted, "BTC", 64250.125, testEpoch))
+	if failed := failedChecks(vm, valid); len(failed) != 0 {
+		t.Errorf("quote from the trusted oracle failed %v", failed)
+	}
+	forged := NewQuotedTransaction(alice, bob, 2, NewOracleQuote(other, "BTC", 64250.125, testEpoch))
+	if failed := failedChecks(vm, forged); len(failed) != 1 || failed[0] != "oracle quote" {
+		t.Errorf("quote from an untrusted key failed %v", failed)
+	}
+
+	// A change far below the sixth decimal must still break the signature
+	tampered := *valid.Quote
+	tampered.Price = 64250.1250000001
+	if tampered.Verify(vm.OraclePublicKey) {
+		t.Error("quote with a tampered price still verifies")
+	}
+
+	if err := vm.AddBlockToChain([]*Transaction{valid}); err != nil {
+		t.Fatal(err)
+	}
+	replay := newTestVM(t)
+	if got := replay.ReplayChain(vm.Blockchain); len(got) != 1 || !strings.HasPrefix(got[0].Reason, "oracle quote") {
+		t.Errorf("replay without the oracle key flagged %+v", got)
+	}
+	replay.OraclePublicKey = vm.OraclePublicKey
+	if got := replay.ReplayChain(vm.Blockchain); len(got) != 0 {
+		t.Errorf("replay with the oracle key flagged %+v", got)
+	}
+}
+
+func TestTransactionsBetween(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob", "carol")
+	first := mustSend(t, vm, "alice", "bob", 1)
+	mustSend(t, vm, "alice", "carol", 2)
+	second := mustSend(t, vm, "bob", "alice", 3)
+	mustSend(t, vm, "carol", "bob", 4)
+
+	for _, pair := range [][2]string{{"alice", "bob"}, {"bob", "alice"}} {
+		got := vm.TransactionsBetween(pair[0], pair[1])
+		if len(got) != 2 || got[0] != first || got[1] != second {
+			t.Errorf("TransactionsBetween(%s, %s) returned %d transfers, want both in chain order", pair[0], pair[1], len(got))
+		}
+	}
+	if got := vm.TransactionsBetween("bob", "nobody"); len(got) != 0 {
+		t.Errorf("TransactionsBetween with an unknown account returned %d transfers", len(got))
+	}
+}
+
+func TestRunLoopStrictMode(t *testing.T) {
+	input := "create_account alice\nsend alice nobody 1\ncreate_account bob\nexit\n"
+
+	vm := newTestVM(t)
+	err := runLoop(vm, strings.NewReader(input), true)
+	if err == nil || !strings.Contains(err.Error(), "send alice nobody 1") {
+		t.Errorf("strict runLoop = %v, want the failing command", err)
+	}
+	if vm.GetAccount("bob") != nil {
+		t.Error("strict runLoop kept going after a failed command")
+	}
+
+	vm = newTestVM(t)
+	if err := runLoop(vm, strings.NewReader(input), false); err != nil {
+		t.Errorf("non-strict runLoop = %v", err)
+	}
+	if vm.GetAccount("bob") == nil {
+		t.Error("non-strict runLoop stopped at a failed command")
+	}
+}
+
+func TestTxCountSeries(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	mustSend(t, vm, "alice", "bob", 1)
+	data, err := NewDataTransaction([]byte("note"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer := NewTransaction(vm.GetAccount("bob"), vm.GetAccount("alice"), 1)
+	if err := vm.AddBlockToChain([]*Transaction{data, transfer}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		includeData bool
+		want        []int
+	}{
+		{true, []int{0, 1, 2}},
+		{false, []int{0, 1, 1}},
+	} {
+		got := vm.Blockchain.TxCountSeries(tc.includeData)
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("TxCountSeries(%v) = %v, want %v", tc.includeData, got, tc.want)
+		}
+	}
+}
+
+func TestViewBlockchainOut(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	mustSend(t, vm, "alice", "bob", 1)
+	path := filepath.Join(t.TempDir(), "chain.txt")
+	if _, err := runCommand(vm, "view_blockchain --full --out "+path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Hash: "+vm.Blockchain.Blocks[1].Hash) {
+		t.Errorf("--out file does not hold the full listing:\n%s", data)
+	}
+
+	missing := filepath.Join(t.TempDir(), "no-such-dir", "chain.txt")
+	if _, err := runCommand(vm, "view_blockchain --out "+missing); err == nil {
+		t.Error("writing to a missing directory succeeded")
+	}
+	if _, err := runCommand(vm, "view_blockchain --out"); err == nil {
+		t.Error("--out without a path succeeded")
+	}
+}
+
+func TestParseAmountUnits(t *testing.T) {
+	vm := newTestVM(t)
+	valid := map[string]float64{"1k": 1000, "2.5m": 2500000, "2.5M": 2500000, "42": 42, "0.01k": 10}
+	for input, want := range valid {
+		if got, err := vm.ParseAmount(input); err != nil || got != want {
+			t.Errorf("ParseAmount(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"1x", "5kk", "k", "1.5e3k"} {
+		if got, err := vm.ParseAmount(input); err == nil {
+			t.Errorf("ParseAmount(%q) = %v, want an error", input, got)
+		}
+	}
+}
+
+func TestMostActiveAccount(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob", "carol")
+	if name, count := vm.MostActiveAccount(); name != "" || count != 0 {
+		t.Errorf("MostActiveAccount on an empty chain = %q, %d", name, count)
+	}
+	mustSend(t, vm, "carol", "bob", 1)
+	mustSend(t, vm, "alice", "carol", 1)
+	// carol is in 2 transfers, bob and alice in 1 each
+	if name, count := vm.MostActiveAccount(); name != "carol" || count != 2 {
+		t.Errorf("MostActiveAccount = %q, %d; want carol, 2", name, count)
+	}
+	mustSend(t, vm, "bob", "alice", 1)
+	// Every account is now in 2 transfers; the tie goes to the smallest username
+	if name, count := vm.MostActiveAccount(); name != "alice" || count != 2 {
+		t.Errorf("MostActiveAccount on a tie = %q, %d; want alice, 2", name, count)
+	}
+}
+
+func TestTimeFormat(t *testing.T) {
+	clock := &fakeClock{now: testEpoch.Add(90 * time.Minute)}
+	vm := NewVirtualMachineWithOptions(VMOptions{Clock: clock})
+	if got := vm.FormatTime(testEpoch); got != "2024-01-01T00:00:00Z" {
+		t.Errorf("default FormatTime = %q", got)
+	}
+	vm.TimeFormat = "2006-01-02 15:04"
+	var out strings.Builder
+	viewBlockchain(vm, &out, false)
+	if !strings.Contains(out.String(), "Timestamp: 2024-01-01 01:30\n") {
+		t.Errorf("viewBlockchain ignored TimeFormat:\n%s", out.String())
+	}
+	vm.TimeFormat = ""
+	if got := vm.FormatTime(testEpoch); got != "2024-01-01T00:00:00Z" {
+		t.Errorf("FormatTime with an empty layout = %q", got)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	write := func(t *testing.T, contents string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		config, err := LoadConfig(write(t, `{"display_decimals": 4, "min_amount": 1, "rounding_mode": "down"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.DisplayDecimals != 4 || config.MinAmount != 1 || config.RoundingMode != "down" {
+			t.Errorf("settings from the file were not applied: %+v", config)
+		}
+		if config.DisplayHashLen != 12 || config.ProvenanceDepth != 3 || len(config.AmountUnits) != len(DefaultAmountUnits) {
+			t.Errorf("settings missing from the file lost their defaults: %+v", config)
+		}
+		vm := NewVirtualMachineFromConfig(config)
+		if vm.DisplayDecimals != 4 || vm.MinAmount != 1 || vm.RoundingMode != RoundDown {
+			t.Error("NewVirtualMachineFromConfig did not apply the config")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, contents := range []string{
+			`{"display_decimals": -1}`,
+			`{"rounding_mode": "sideways"}`,
+			`{"amount_units": {"K": 1000}}`,
+			`{"amount_units": {"k": 0}}`,
+			`{"display_decimals": `,
+		} {
+			if _, err := LoadConfig(write(t, contents)); err == nil {
+				t.Errorf("LoadConfig accepted %s", contents)
+			}
+		}
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		_, err := LoadConfig(write(t, `{"display_decimal": 4}`))
+		if err == nil || !strings.Contains(err.Error(), "display_decimal") {
+			t.Errorf("LoadConfig with a misspelled field = %v", err)
+		}
+	})
+
+	t.Run("username policy and oracle key", func(t *testing.T) {
+		key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+		public := key.Public().(ed25519.PublicKey)
+		config, err := LoadConfig(write(t, `{"username_policy": {"max_length": 8, "case_sensitive": false}, "oracle_public_key": "`+hex.EncodeToString(public)+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm := NewVirtualMachineFromConfig(config)
+		if vm.UsernamePolicy.MaxLength != 8 || vm.UsernamePolicy.CaseSensitive || vm.UsernamePolicy.AllowedChars != DefaultUsernamePolicy.AllowedChars {
+			t.Errorf("UsernamePolicy = %+v", vm.UsernamePolicy)
+		}
+		if !bytes.Equal(vm.OraclePublicKey, public) {
+			t.Error("OraclePublicKey was not applied")
+		}
+		if _, err := vm.CreateAccount("verylongname"); !errors.Is(err, ErrInvalidUsername) {
+			t.Errorf("CreateAccount past max_length = %v", err)
+		}
+		for _, contents := range []string{
+			`{"username_policy": {"min_length": -1}}`,
+			`{"username_policy": {"min_length": 5, "max_length": 3}}`,
+			`{"oracle_public_key": "abcd"}`,
+			`{"oracle_public_key": "not hex"}`,
+		} {
+			if _, err := LoadConfig(write(t, contents)); err == nil {
+				t.Errorf("LoadConfig accepted %s", contents)
+			}
+		}
+	})
+
+	t.Run("amount units replace the defaults", func(t *testing.T) {
+		config, err := LoadConfig(write(t, `{"amount_units": {"b": 1e9}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(config.AmountUnits) != 1 || config.AmountUnits["b"] != 1e9 {
+			t.Errorf("AmountUnits = %v, want only b", config.AmountUnits)
+		}
+		if DefaultAmountUnits["b"] != 0 || len(DefaultAmountUnits) != 2 {
+			t.Errorf("loading a config changed DefaultAmountUnits to %v", DefaultAmountUnits)
+		}
+	})
+}
+
+func TestBalanceDelta(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	mustSend(t, vm, "alice", "bob", 5) // block 1, at 1s
+	mustSend(t, vm, "bob", "alice", 2) // block 2, at 2s
+	mustSend(t, vm, "alice", "bob", 1) // block 3, at 3s
+	at := func(seconds int64) time.Time { return time.Unix(seconds, 0).UTC() }
+
+	for _, tc := range []struct {
+		from, to int64
+		want     float64
+	}{
+		{0, 3, -4},
+		{1, 2, -3},
+		{2, 3, 1},
+		{2, 2, 2},
+		{4, 9, 0},
+	} {
+		got, err := vm.BalanceDelta("alice", at(tc.from), at(tc.to))
+		if err != nil || got != tc.want {
+			t.Errorf("BalanceDelta(alice, %ds, %ds) = %v, %v; want %v", tc.from, tc.to, got, err, tc.want)
+		}
+	}
+	if _, err := vm.BalanceDelta("alice", at(3), at(1)); err == nil {
+		t.Error("BalanceDelta with from after to succeeded")
+	}
+	if _, err := vm.BalanceDelta("nobody", at(0), at(3)); err == nil {
+		t.Error("BalanceDelta for an unknown account succeeded")
+	}
+}
+
+func TestReportByCategory(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	alice, bob := vm.GetAccount("alice"), vm.GetAccount("bob")
+	rent := NewCategorizedTransaction(alice, bob, 10, "rent")
+	food := NewCategorizedTransaction(alice, bob, 10, "food")
+	if rent.ID == food.ID {
+		t.Error("transactions differing only in category share an ID")
+	}
+	txs := []*Transaction{rent, food, NewCategorizedTransaction(bob, alice, 2.5, "food"), NewTransaction(bob, alice, 1)}
+	if err := vm.AddBlockToChain(txs); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{"rent": 10, "food": 12.5, "": 1}
+	got := vm.ReportByCategory()
+	if len(got) != len(want) {
+		t.Errorf("ReportByCategory() = %v, want %v", got, want)
+	}
+	for category, total := range want {
+		if got[category] != total {
+			t.Errorf("total for %q = %v, want %v", category, got[category], total)
+		}
+	}
+}
+
+func TestBlocksBetweenTimes(t *testing.T) {
+	bc := NewBlockchain(testEpoch)
+	for _, minutes := range []int{10, 20, 30} {
+		bc.AddBlock(nil, testEpoch.Add(time.Duration(minutes)*time.Minute))
+	}
+	heights := func(blocks []*Block) []int {
+		var got []int
+		for _, block := range blocks {
+			for height, b := range bc.Blocks {
+				if b == block {
+					got = append(got, height)
+				}
+			}
+		}
+		return got
+	}
+
+	got := heights(bc.BlocksBetweenTimes(testEpoch.Add(10*time.Minute), testEpoch.Add(20*time.Minute)))
+	if fmt.Sprint(got) != "[1 2]" {
+		t.Errorf("blocks on the window boundaries = %v, want [1 2]", got)
+	}
+	if got := bc.BlocksBetweenTimes(testEpoch.Add(time.Hour), testEpoch.Add(2*time.Hour)); len(got) != 0 {
+		t.Errorf("window after the tip returned %d blocks", len(got))
+	}
+
+	// Out-of-order timestamps must not hide later blocks from the scan
+	bc.AddBlock(nil, testEpoch.Add(15*time.Minute))
+	got = heights(bc.BlocksBetweenTimes(testEpoch.Add(12*time.Minute), testEpoch.Add(18*time.Minute)))
+	if fmt.Sprint(got) != "[4]" {
+		t.Errorf("window around an out-of-order block = %v, want [4]", got)
+	}
+}
+
+func TestRoundingModes(t *testing.T) {
+	vm := newTestVM(t)
+	for _, tc := range []struct {
+		mode     RoundingMode
+		in, want float64
+	}{
+		{RoundHalfEven, 0.125, 0.12},
+		{RoundHalfEven, 0.135, 0.14},
+		{RoundHalfUp, 0.125, 0.13},
+		{RoundHalfUp, -0.125, -0.13},
+		{RoundDown, 0.125, 0.12},
+		{RoundDown, 0.129, 0.12},
+		{RoundDown, -0.129, -0.12},
+	} {
+		vm.RoundingMode = tc.mode
+		if got := vm.RoundAmount(tc.in); got != tc.want {
+			t.Errorf("RoundAmount(%v) in mode %d = %v, want %v", tc.in, tc.mode, got, tc.want)
+		}
+	}
+	vm.RoundingMode = RoundHalfUp
+	if got := vm.FormatAmount(0.125); got != "0.13" {
+		t.Errorf("FormatAmount(0.125) half up = %q", got)
+	}
+	if _, err := ParseRoundingMode("sideways"); err == nil {
+		t.Error("ParseRoundingMode accepted an unknown mode")
+	}
+}
+
+func TestCheckUniqueTxIDs(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	tx := mustSend(t, vm, "alice", "bob", 1)
+	mustSend(t, vm, "bob", "alice", 1)
+	if got := vm.Blockchain.CheckUniqueTxIDs(); len(got) != 0 {
+		t.Errorf("clean chain reported duplicates %v", got)
+	}
+
+	// A replayed transfer, included twice more, is reported once
+	vm.Blockchain.AddBlock([]*Transaction{tx, tx}, testEpoch)
+	if got := vm.Blockchain.CheckUniqueTxIDs(); len(got) != 1 || got[0] != tx.ID {
+		t.Errorf("CheckUniqueTxIDs() = %v, want [%s]", got, tx.ID)
+	}
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	vm := newTestVM(t, "alice", "bob")
+	vm.EnterMaintenance()
+	if !vm.InMaintenance() {
+		t.Fatal("InMaintenance() = false after EnterMaintenance")
+	}
+	if _, err := vm.CreateAccount("carol"); !errors.Is(err, ErrMaintenance) {
+		t.Errorf("CreateAccount in maintenance = %v", err)
+	}
+	tx := NewTransaction(vm.GetAccount("alice"), vm.GetAccount("bob"), 1)
+	if err := vm.AddBlockToChain([]*Transaction{tx}); !errors.Is(err, ErrMaintenance) {
+		t.Errorf("AddBlockToChain in maintenance = %v", err)
+	}
+	if len(vm.Blockchain.Blocks) != 1 {
+		t.Error("a block was mined in maintenance mode")
+	}
+	// Reads carry on
+	if tip := vm.TipInfo(); tip.Height != 0 {
+		t.Errorf("TipInfo in maintenance = %+v", tip)
+	}
+
+	// A send refused by maintenance still reaches the dead-letter sink
+	var sink bytes.Buffer
+	vm.DeadLetter = &sink
+	if _, err := runCommand(vm, "send alice bob 1"); err == nil {
+		t.Error("send in maintenance succeeded")
+	}
+	var record RejectedTransaction
+	if err := json.Unmarshal(sink.Bytes(), &record); err != nil || record.Reason != ErrMaintenance.Error() {
+		t.Errorf("dead-letter record %q: %v", sink.String(), err)
+	}
+	vm.DeadLetter = nil
+
+	vm.ExitMaintenance()
+	if _, err := vm.CreateAccount("carol"); err != nil {
+		t.Errorf("CreateAccount after maintenance = %v", err)
+	}
+	mustSend(t, vm, "alice", "bob", 1)
+}
+
+func TestViewBlockchainRejectsUnknownArguments(t *testing.T) {
+	vm := newTestVM(t)
+	for _, command := range []string{"view_blockchain --jsonn", "view_blockchain --json --full", "view_blockchain full"} {
+		if _, err := runCommand(vm, command); err == nil {
+			t.Errorf("%q succeeded", command)
+		}
+	}
+	for _, command := range []string{"view_blockchain", "view_blockchain --json", "view_blockchain --full"} {
+		if _, err := runCommand(vm, command); err != nil {
+			t.Errorf("%q = %v", command, err)
+		}
+	}
+}
+
+func TestLoadChainChecksAccountNames(t *testing.T) {
+	// chainWith builds a chain with one transfer between the given usernames,
+	// created on a VM that accepts any name
+	chainWith := func(from, to string) *Blockchain {
+		source := newTestVM(t)
+		source.UsernamePolicy = UsernamePolicy{CaseSensitive: true}
+		for _, name := range []string{from, to} {
+			if _, err := source.CreateAccount(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mustSend(t, source, from, to, 1)
+		return source.Blockchain
+	}
+
+	vm := newTestVM(t, "bob")
+	if err := vm.LoadChain(chainWith("a@b!", "bob")); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("LoadChain with a disallowed name = %v", err)
+	}
+	if vm.GetAccount("a@b!") != nil || len(vm.Accounts) != 1 || len(vm.Blockchain.Blocks) != 1 {
+		t.Error("a rejected chain changed the VM")
+	}
+
+	// Case-insensitive VM: "Alice" must not be re-pointed at "alice"
+	vm = newTestVM(t)
+	vm.UsernamePolicy.CaseSensitive = false
+	if _, err := vm.CreateAccount("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.LoadChain(chainWith("Alice", "bob")); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("LoadChain with Alice over alice = %v", err)
+	}
+	if vm.GetAccount("bob") != nil || len(vm.Blockchain.Blocks) != 1 {
+		t.Error("a rejected chain changed the VM")
+	}
+	if err := vm.LoadChain(chainWith("alice", "bob")); err != nil {
+		t.Fatalf("LoadChain with lower-case names = %v", err)
+	}
+	if err := vm.Blockchain.ValidateChain(); err != nil {
+		t.Errorf("loaded chain no longer validates: %v", err)
+	}
+	if tx := vm.Blockchain.Blocks[1].Transactions[0]; tx.Sender != vm.GetAccount("alice") || tx.Receiver != vm.GetAccount("bob") {
+		t.Error("loaded transfer does not point at the VM's accounts")
+	}
+}